@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/hagna/scoreme/pkg/scoreme"
+)
+
+// writeJSON sends v as the JSON response.
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+// apiError sends a JSON error response.
+func apiError(w http.ResponseWriter, code int, msg string) {
+	writeJSON(w, code, map[string]string{"error": msg})
+}
+
+// handleSubmissions scores a POST of candidate passwords for the team, sent
+// either as a JSON array of strings or as newline separated text. The
+// input query value is their format, such as hash for hashes sent without
+// the passwords.
+func (s *server) handleSubmissions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		apiError(w, http.StatusMethodNotAllowed, "POST only")
+		return
+	}
+	name, ok := s.team(w, r)
+	if !ok {
+		return
+	}
+	defer r.Body.Close()
+	input, err := queryInput(r.URL.Query())
+	if err != nil {
+		apiError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	var sc *bufio.Scanner
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		var candidates []string
+		if err := json.NewDecoder(r.Body).Decode(&candidates); err != nil {
+			apiError(w, http.StatusBadRequest, "want a JSON array of strings: "+err.Error())
+			return
+		}
+		var b bytes.Buffer
+		for _, c := range candidates {
+			if strings.ContainsAny(c, "\r\n") {
+				apiError(w, http.StatusBadRequest, "passwords can't contain newlines")
+				return
+			}
+			b.WriteString(c + "\n")
+		}
+		sc = bufio.NewScanner(&b)
+	} else {
+		sc = bufio.NewScanner(r.Body)
+	}
+	sub, err := s.comp.Submit(r.Context(), name, input, sc, nil)
+	if err != nil {
+		errorf("%s: %s", name, err)
+		if sub == nil {
+			apiError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+	writeJSON(w, http.StatusCreated, sub)
+}
+
+// handleSubmission returns one of the team's submissions by id.
+func (s *server) handleSubmission(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		apiError(w, http.StatusMethodNotAllowed, "GET only")
+		return
+	}
+	name, ok := s.team(w, r)
+	if !ok {
+		return
+	}
+	id, err := strconv.ParseUint(strings.TrimPrefix(r.URL.Path, "/api/v1/submissions/"), 10, 64)
+	if err != nil {
+		apiError(w, http.StatusNotFound, "bad submission id")
+		return
+	}
+	sub, err := s.comp.Submission(id)
+	if err == scoreme.ErrNoSubmission || (err == nil && sub.Team != name) {
+		apiError(w, http.StatusNotFound, scoreme.ErrNoSubmission.Error())
+		return
+	}
+	if err != nil {
+		apiError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, sub)
+}
+
+// handleScore returns the team's standing.
+func (s *server) handleScore(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		apiError(w, http.StatusMethodNotAllowed, "GET only")
+		return
+	}
+	name, ok := s.team(w, r)
+	if !ok {
+		return
+	}
+	t, err := s.comp.Team(name)
+	if err != nil {
+		apiError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, t)
+}
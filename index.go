@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"time"
+
+	"github.com/hagna/scoreme/pkg/scoreme"
+)
+
+func init() {
+	var (
+		f         indexFlags
+		batchsize int
+		resume    bool
+		merge     bool
+		workers   int
+		tmpdir    string
+		sortmem   int64
+		filter    float64
+	)
+	c := newCommand("index", "[passwdfile]", "Add the records of a passwd file to the index")
+	f.register(c.flags)
+	c.flags.IntVar(&batchsize, "batchsize", 100000, "Batch size for indexing, a checkpoint is saved after every batch.")
+	c.flags.BoolVar(&resume, "resume", false, "Resume an interrupted build of the same passwd file from its last checkpoint.")
+	c.flags.BoolVar(&merge, "merge", false, "Merge an update into the index: add the new hashes and update the counts of those already in it, instead of adding every line.")
+	c.flags.IntVar(&workers, "workers", runtime.NumCPU(), "Goroutines parsing lines for the bolt backends, which then write a batch per transaction. With 0 lines are inserted one by one.")
+	c.flags.StringVar(&tmpdir, "tmpdir", os.TempDir(), "Directory to sort an unsorted passwd file in for the boltbatch backend, which needs it sorted by hash.")
+	c.flags.Int64Var(&sortmem, "sortmem", 256, "Megabytes of lines to sort in memory at a time.")
+	c.flags.Float64Var(&filter, "filter", 0, "False positive rate of a filter of the index, made after the build and kept next to it so scoring can skip most lookups of misses, e.g. 0.01 (default the rate of the filter already there, if any). Without a passwdfile only the filter is made.")
+	c.run = func(args []string) int {
+		if code, ok := c.parse(args, 0, 1); !ok {
+			return code
+		}
+		fpath := scoreme.FilterPath(f.options().Path)
+		if filter == 0 && scoreme.Exists(fpath) {
+			if flt, err := scoreme.OpenFilter(fpath); err == nil {
+				filter = flt.Meta().Rate
+				flt.Close()
+			}
+		}
+		if c.flags.NArg() == 0 && filter == 0 {
+			c.flags.Usage()
+			return exitUsage
+		}
+		if c.flags.NArg() == 0 {
+			if err := writeFilter(&f, fpath, filter); err != nil {
+				errorf("%s", err)
+				return exitFail
+			}
+			return exitOK
+		}
+		idx, err := f.create()
+		if err != nil {
+			errorf("%s", err)
+			return exitFail
+		}
+		fmt.Printf("Update %s\n", f.options().Path)
+		start := time.Now()
+		stats, err := scoreme.Build(idx, c.flags.Arg(0), scoreme.BuildOptions{
+			BatchSize:  batchsize,
+			Progress:   progress,
+			Resume:     resume,
+			Merge:      merge,
+			Workers:    workers,
+			TempDir:    tmpdir,
+			SortMemory: sortmem << 20,
+		})
+		if cerr := idx.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			errorf("%s", err)
+			return exitFail
+		}
+		elapsed := time.Since(start)
+		if merge {
+			fmt.Printf("%d added, %d changed, %d unchanged\n", stats.Added, stats.Changed, stats.Unchanged)
+		}
+		fmt.Printf("%d hashes in the index, built in %s\n", idx.Meta().Records, elapsed.Round(time.Millisecond))
+		if filter != 0 {
+			if err := writeFilter(&f, fpath, filter); err != nil {
+				errorf("%s", err)
+				return exitFail
+			}
+		}
+		return exitOK
+	}
+}
+
+// writeFilter makes the filter of the index and saves it to path. The
+// index is opened again, as some backends only write it on Close.
+func writeFilter(f *indexFlags, path string, rate float64) error {
+	start := time.Now()
+	idx, err := f.open()
+	if err != nil {
+		return err
+	}
+	defer idx.Close()
+	flt, err := scoreme.BuildFilter(idx, rate)
+	if err != nil {
+		return err
+	}
+	if err := flt.Write(path); err != nil {
+		return err
+	}
+	m := flt.Meta()
+	fmt.Printf("Filter %s of %d hashes, %d bits and %d hashes each for a false positive rate of %g, made in %s\n",
+		path, m.Records, m.Bits, m.Hashes, m.Rate, time.Since(start).Round(time.Millisecond))
+	return nil
+}
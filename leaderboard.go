@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const leaderboardHTML = `<!DOCTYPE html>
+<html><head><title>Leaderboard</title>
+<style>
+table { border-collapse: collapse; }
+th, td { padding: 0.2em 1em; text-align: right; }
+th:nth-child(2), td:nth-child(2) { text-align: left; }
+</style></head>
+<body><h1>Leaderboard</h1>
+<table><thead><tr><th>Rank</th><th>Team</th><th>Score</th><th>Bonus</th><th>Hits</th></tr></thead>
+<tbody id="teams"></tbody></table>
+<script>
+var src = new EventSource("/leaderboard/stream");
+src.addEventListener("leaderboard", function(e) {
+	var body = document.getElementById("teams");
+	body.innerHTML = "";
+	JSON.parse(e.data).forEach(function(t) {
+		var tr = document.createElement("tr");
+		[t.rank, t.name, t.score, t.bonus.toFixed(2), t.hits].forEach(function(v) {
+			var td = document.createElement("td");
+			td.textContent = v;
+			tr.appendChild(td);
+		});
+		body.appendChild(tr);
+	});
+});
+</script></body></html>
+`
+
+// keepAlive is how often the stream sends a comment so proxies don't drop
+// an idle connection.
+var keepAlive = 30 * time.Second
+
+func (s *server) handleLeaderboard(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html")
+	fmt.Fprint(w, leaderboardHTML)
+}
+
+// handleLeaderboardStream sends the ranked teams as a server-sent
+// "leaderboard" event now and after every change.
+func (s *server) handleLeaderboardStream(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	changed, stop := s.comp.Watch()
+	defer stop()
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	tick := time.NewTicker(keepAlive)
+	defer tick.Stop()
+	send := true
+	for {
+		if send {
+			b, err := json.Marshal(s.comp.Teams())
+			if err != nil {
+				errorf("%s", err)
+				return
+			}
+			if _, err := fmt.Fprintf(w, "event: leaderboard\ndata: %s\n\n", b); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+		select {
+		case <-changed:
+			send = true
+		case <-tick.C:
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+			send = false
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hagna/scoreme/pkg/scoreme"
+)
+
+func TestLeaderboardStreamKeepAlive(t *testing.T) {
+	old := keepAlive
+	keepAlive = 20 * time.Millisecond
+	defer func() { keepAlive = old }()
+	comp, err := scoreme.OpenCompetition(filepath.Join(t.TempDir(), "competition.db"), nil, scoreme.DefaultRules, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer comp.Close()
+	s := &server{comp: comp}
+	ts := httptest.NewServer(http.HandlerFunc(s.handleLeaderboardStream))
+	defer ts.Close()
+	resp, err := http.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	// next returns the lines of the next message of the stream.
+	br := bufio.NewReader(resp.Body)
+	next := func() []string {
+		t.Helper()
+		var lines []string
+		for {
+			l, err := br.ReadString('\n')
+			if err != nil {
+				t.Fatal(err)
+			}
+			if l == "\n" {
+				return lines
+			}
+			lines = append(lines, strings.TrimSuffix(l, "\n"))
+		}
+	}
+	if msg := next(); len(msg) == 0 || msg[0] != "event: leaderboard" {
+		t.Fatalf("the stream starts with %q, want the leaderboard", msg)
+	}
+	for i := 0; i < 3; i++ {
+		if msg := next(); len(msg) != 1 || msg[0] != ": ping" {
+			t.Fatalf("a tick sent %q, want only a comment", msg)
+		}
+	}
+	if err := comp.AddTeam("a", "a-token"); err != nil {
+		t.Fatal(err)
+	}
+	for {
+		msg := next()
+		if msg[0] == ": ping" {
+			continue
+		}
+		if len(msg) != 2 || msg[0] != "event: leaderboard" || !strings.Contains(msg[1], `"name":"a"`) {
+			t.Fatalf("a change sent %q, want the leaderboard with team a", msg)
+		}
+		break
+	}
+}
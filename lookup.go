@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/hagna/scoreme/pkg/scoreme"
+)
+
+// found is one lookup in -format=json output.
+type found struct {
+	Hash  string `json:"hash"`
+	Found bool   `json:"found"`
+	Count int    `json:"count"`
+}
+
+func init() {
+	var (
+		f      indexFlags
+		hashes bool
+		format string
+	)
+	c := newCommand("lookup", "password...", "Print the breach count of each password, exiting 1 if any is not found")
+	f.register(c.flags)
+	c.flags.BoolVar(&hashes, "hash", false, "The arguments are hex hashes rather than passwords.")
+	c.flags.StringVar(&format, "format", "text", "Print the counts as text or as a json array.")
+	c.run = func(args []string) int {
+		if code, ok := c.parse(args, 1); !ok {
+			return code
+		}
+		if err := checkFormat(format); err != nil {
+			errorf("%s", err)
+			return exitUsage
+		}
+		idx, err := f.openFiltered()
+		if err != nil {
+			errorf("%s", err)
+			return exitFail
+		}
+		defer idx.Close()
+		code := exitOK
+		var res []found
+		for _, a := range c.flags.Args() {
+			h := idx.Meta().Algorithm.Hash([]byte(a))
+			if hashes {
+				h = strings.ToUpper(a)
+			}
+			count, err := idx.Lookup(h)
+			switch err {
+			case nil:
+				res = append(res, found{h, true, count})
+			case scoreme.ErrNotFound:
+				res = append(res, found{Hash: h})
+				code = exitFail
+			default:
+				errorf("%s: %s", h, err)
+				return exitFail
+			}
+		}
+		if format == "json" {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			if err := enc.Encode(res); err != nil {
+				errorf("%s", err)
+				return exitFail
+			}
+			return code
+		}
+		for _, r := range res {
+			if r.Found {
+				fmt.Printf("%s %d\n", r.Hash, r.Count)
+			} else {
+				fmt.Printf("%s not found\n", r.Hash)
+			}
+		}
+		return code
+	}
+}
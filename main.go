@@ -1,244 +1,249 @@
+// Command scoreme indexes the pwned passwords list and scores cracked
+// passwords against it.
 package main
 
 import (
-	"bufio"
-	"bytes"
-	"crypto/sha1"
 	"flag"
 	"fmt"
-	"io/ioutil"
 	"os"
-	"strconv"
 	"strings"
 	"time"
+
+	"github.com/hagna/scoreme/pkg/scoreme"
 )
 
-var (
-	passwdfile = flag.String("passwd", os.Getenv("HOME")+"/passwd", "Password file to check")
-	timeout    = flag.Duration("timeout", 2*time.Minute, "Timeout")
-	datadir    = flag.String("datadir", os.Getenv("HOME")+"/data", "The dir containing the hash tree.")
-	update     = flag.Bool("update", false, "Update the datadir")
-	prefixlen  = flag.Uint("prefixlen", 8, "Prefix length to use for generating hash tree.")
-	splitlen   = flag.Uint("splitlen", 2, "Path length")
-	debug      = flag.Bool("debug", false, "Turn on debug.")
-	batchsize  = flag.Int("batchsize", 100000, "Batch size for indexing")
-	rules      = `
-The rules are these:
-1. -1 for missing
-2. +1 points for each valid hash
-3. Add bonus for rare passwords that only occur twice as in 04E2B8C988822005B768843B50A08BABDBA654FD:2
-4. If timeout happens before scoring you don't get any points.
-`
-	usage = func() {
-		fmt.Fprintf(flag.CommandLine.Output(), "Usage of %s:\n", os.Args[0])
-		flag.PrintDefaults()
-		fmt.Fprintf(flag.CommandLine.Output(), rules)
-	}
+// Exit codes.
+const (
+	exitOK      = 0
+	exitFail    = 1
+	exitUsage   = 2
+	exitTimeout = 3
 )
 
-// exists checks if a file or directory exists.
-func exists(path string) bool {
-	if path == "" {
-		return false
-	}
-	_, err := os.Stat(path)
-	if err == nil {
-		return true
-	}
-	if !os.IsNotExist(err) {
-		return false
-	}
-	return false
+// A command is one scoreme subcommand.
+type command struct {
+	name  string
+	args  string
+	short string
+	flags *flag.FlagSet
+	run   func(args []string) int
 }
 
-func splitN(n uint) func(string) []string {
-	return func(a string) []string {
-		var res []string
-		var i uint
-		for i = 0; i < uint(len(a)); i += n {
-			if uint(len(a[i:])) < n {
-				res = append(res, a[i:])
-			} else {
-				res = append(res, a[i:i+n])
-			}
-		}
-		return res
+var commands []*command
+
+func newCommand(name, args, short string) *command {
+	c := &command{name: name, args: args, short: short}
+	c.flags = flag.NewFlagSet(name, flag.ContinueOnError)
+	c.flags.Usage = c.printUsage
+	commands = append(commands, c)
+	return c
+}
+
+func (c *command) printUsage() {
+	fmt.Fprintf(c.flags.Output(), "Usage: scoreme %s [flags] %s\n\n%s.\n\nFlags:\n", c.name, c.args, c.short)
+	c.flags.PrintDefaults()
+}
+
+func usage() {
+	w := os.Stderr
+	fmt.Fprintf(w, "Usage: scoreme <command> [flags] [args]\n\nCommands:\n")
+	for _, c := range commands {
+		fmt.Fprintf(w, "  %-8s %s\n", c.name, c.short)
 	}
+	fmt.Fprintf(w, "\nRun \"scoreme help <command>\" for the flags of a command.\n")
+	fmt.Fprintf(w, "\nExit status is %d on success, %d on failure, %d on bad usage and %d on timeout.\n",
+		exitOK, exitFail, exitUsage, exitTimeout)
+	fmt.Fprint(w, scoreme.DefaultRules)
+}
+
+// indexFlags are the flags shared by every command that opens an index.
+type indexFlags struct {
+	backend   string
+	path      string
+	bucket    string
+	prefixlen uint
+	splitlen  uint
+	algorithm string
+	debug     bool
 }
 
-func NewTreeEntry(key, val string) error {
-	return mkTreeEntry(splitN(*splitlen), key, val)
+func (f *indexFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.backend, "backend", "boltbatch", "Index backend, one of "+strings.Join(scoreme.Backends, ", ")+".")
+	fs.StringVar(&f.path, "index", "", "The hash tree dir, boltdb file or flat file (default $HOME/data for fstree, ./db otherwise).")
+	fs.StringVar(&f.bucket, "bucketname", "bucket1", "Bucket name for boltdb.")
+	fs.UintVar(&f.prefixlen, "prefixlen", 0, "Prefix length of a new index (default 4 for boltbatch and flat, 8 otherwise). An existing index knows its own.")
+	fs.UintVar(&f.splitlen, "splitlen", 0, "Path length of a new hash tree (default 2). An existing index knows its own.")
+	fs.StringVar(&f.algorithm, "algorithm", "", "Hash algorithm of a new index, sha1 or ntlm (default sha1). An existing index knows its own.")
+	fs.BoolVar(&f.debug, "debug", false, "Turn on debug.")
 }
 
-func mkTreeEntry(splitter func(string) []string, key, val string) error {
-	p := splitter(key)
-	path := *datadir + "/" + strings.Join(p, "/")
-	if err := os.MkdirAll(path, 0744); err != nil {
-		return err
+func (f *indexFlags) options() scoreme.Options {
+	o := scoreme.Options{
+		Path:      f.path,
+		Bucket:    f.bucket,
+		PrefixLen: f.prefixlen,
+		SplitLen:  f.splitlen,
+		Debug:     f.debug,
 	}
-	t := path + "/v"
-	if !exists(t) {
-		if fh, err := os.Create(t); err != nil {
-			return err
+	if o.Path == "" {
+		if f.backend == "fstree" {
+			o.Path = os.Getenv("HOME") + "/data"
 		} else {
-			fh.Write([]byte(val + "\n"))
-			defer fh.Close()
+			o.Path = "./db"
 		}
-	} else {
-		if dat, err := ioutil.ReadFile(t); err != nil {
-			return err
-		} else {
-			if err := ioutil.WriteFile(t, append(dat, []byte(fmt.Sprintf("%s\n", val))...), 0644); err != nil {
-				return err
-			}
+	}
+	return o
+}
+
+// info describes the index for results.
+func (f *indexFlags) info(idx scoreme.Index) *scoreme.IndexInfo {
+	info := &scoreme.IndexInfo{Path: f.options().Path, Meta: idx.Meta()}
+	if fi, ok := idx.(*scoreme.FilteredIndex); ok {
+		m := fi.Filter.Meta()
+		info.Filter = &m
+	}
+	return info
+}
+
+// keyOptions returns the options along with the hash algorithm.
+func (f *indexFlags) keyOptions() (scoreme.Options, error) {
+	o := f.options()
+	if f.algorithm != "" {
+		alg, err := scoreme.ParseAlgorithm(f.algorithm)
+		if err != nil {
+			return o, err
 		}
+		o.Algorithm = alg
 	}
-	return nil
+	return o, nil
 }
 
-func getHash(h string) ([]byte, error) {
-	p := splitN(*splitlen)(h[:*prefixlen])
-	path := *datadir + "/" + strings.Join(p, "/") + "/v"
-	if !exists(path) {
-		return nil, fmt.Errorf("%s (%s) not found", h, path)
+// open opens the index to read it.
+func (f *indexFlags) open() (scoreme.Index, error) {
+	o, err := f.keyOptions()
+	if err != nil {
+		return nil, err
 	}
-	if dat, err := ioutil.ReadFile(path); err != nil {
+	return scoreme.Open(f.backend, o)
+}
+
+// create opens the index to add to it, making it if there is none.
+func (f *indexFlags) create() (scoreme.Index, error) {
+	o, err := f.keyOptions()
+	if err != nil {
 		return nil, err
-	} else {
-		return dat, err
 	}
+	o.Create = true
+	return scoreme.Open(f.backend, o)
 }
 
-func main() {
-	flag.Usage = usage
-	flag.Parse()
-	var score int
-	var escore float32
-	var hashes []string
-
-	if !exists(*datadir) || *update {
-		if !*update {
-			fmt.Printf("%s doesn't exist so creating the index there.\n", *datadir)
-		} else {
-			fmt.Print("Update\n")
+// openFiltered opens the index for scoring, with the filter next to it in
+// front if there is one. A filter that does not fit the index is left out.
+func (f *indexFlags) openFiltered() (scoreme.Index, error) {
+	idx, err := f.open()
+	if err != nil {
+		return nil, err
+	}
+	path := scoreme.FilterPath(f.options().Path)
+	if !scoreme.Exists(path) {
+		return idx, nil
+	}
+	flt, err := scoreme.OpenFilter(path)
+	if err == nil {
+		if err = flt.Fits(idx.Meta()); err != nil {
+			flt.Close()
 		}
-		pfile, err := os.Open(*passwdfile)
+	}
+	if err != nil {
+		errorf("%s, going without it", err)
+		return idx, nil
+	}
+	return &scoreme.FilteredIndex{Index: idx, Filter: flt}, nil
+}
+
+// rulesFlags select the scoring rules.
+type rulesFlags struct {
+	path string
+}
+
+func (f *rulesFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.path, "rules", "", "TOML file with the scoring rules (default the rules below).")
+}
+
+func (f *rulesFlags) load() (scoreme.Rules, error) {
+	if f.path == "" {
+		return scoreme.DefaultRules, nil
+	}
+	return scoreme.LoadRules(f.path)
+}
+
+// usage prints the rules after the flags of c, so the rules shown are the
+// ones that would be used.
+func (f *rulesFlags) usage(c *command) {
+	c.flags.Usage = func() {
+		c.printUsage()
+		r, err := f.load()
 		if err != nil {
-			fmt.Println(err)
+			fmt.Fprintf(c.flags.Output(), "\n%s\n", err)
 			return
 		}
-		defer pfile.Close()
-		p := bufio.NewScanner(pfile)
-		b := *batchsize
-		start := time.Now()
-		for {
-			if b < 0 {
-				b = *batchsize
-				t := time.Now()
-				elapsed := t.Sub(start)
-				start = time.Now()
-				fmt.Printf("%d hashes indexed in %s\n", *batchsize, elapsed.String())
-			}
-
-			if ok := p.Scan(); !ok {
-				err := p.Err()
-				if err != nil {
-					fmt.Println(err)
-				}
-				break
-			}
-			l := strings.TrimSpace(p.Text())
-			if err := NewTreeEntry(l[:*prefixlen], l); err != nil {
-				fmt.Println(err)
-				break
-			}
-			b--
-		}
-		fmt.Printf("\n")
-		return
+		fmt.Fprint(c.flags.Output(), r)
 	}
+}
 
-	s := bufio.NewScanner(os.Stdin)
-	for {
-
-		if ok := s.Scan(); !ok {
-			err := s.Err()
-			if err != nil {
-				fmt.Println(err)
-			}
-			break
+// errorf reports a failure on stderr.
+func errorf(format string, a ...interface{}) {
+	fmt.Fprintf(os.Stderr, "scoreme: "+format+"\n", a...)
+}
 
+// parse parses the flags of c, returning the exit code to use if the
+// command should not run.
+func (c *command) parse(args []string, nargs ...int) (int, bool) {
+	if err := c.flags.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return exitOK, false
 		}
-		hashes = append(hashes, fmt.Sprintf("%X", sha1.Sum(s.Bytes())))
+		return exitUsage, false
 	}
-	if *debug {
-		fmt.Printf("opening %s\n", *passwdfile)
+	if len(nargs) > 0 {
+		n := c.flags.NArg()
+		if n < nargs[0] || (len(nargs) > 1 && n > nargs[1]) {
+			c.flags.Usage()
+			return exitUsage, false
+		}
 	}
+	return exitOK, true
+}
 
-	type scoredata struct {
-		score int
-		extra float32
+func progress(p scoreme.Progress) {
+	lines, bytes := p.Rate()
+	fmt.Printf("%5.1f%% %d hashes at %.0f/s (%.1f MB/s), ETA %s\n",
+		p.Percent(), p.Lines, lines, bytes/1e6, p.ETA().Round(time.Second))
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(exitUsage)
 	}
-	hash := make(map[string]*scoredata)
-	for _, v := range hashes {
-		if _, ok := hash[v]; !ok {
-			hash[v] = &scoredata{-1, 0}
-		} else {
-			val := hash[v]
-			val.score = val.score - 1
-			val.extra = val.extra - 1
+	name, args := os.Args[1], os.Args[2:]
+	switch name {
+	case "-h", "-help", "--help":
+		usage()
+		os.Exit(exitOK)
+	case "help":
+		if len(args) == 0 {
+			usage()
+			os.Exit(exitOK)
 		}
+		name, args = args[0], []string{"-h"}
 	}
-	done := make(chan bool)
-	go func() {
-		for k, v := range hash {
-			if dat, err := getHash(k); err != nil {
-				if *debug {
-					fmt.Println(err)
-				}
-				continue
-			} else {
-				p := bufio.NewScanner(bytes.NewReader(dat))
-				for {
-					if ok := p.Scan(); !ok {
-						err := p.Err()
-						if err != nil {
-							fmt.Println(err)
-						}
-						break
-					}
-					l := p.Text()
-					f := strings.Split(strings.TrimSpace(l), ":")
-					h := f[0]
-					extra, err := strconv.Atoi(f[1])
-					if err != nil {
-						fmt.Println(err)
-						return
-					}
-					if *debug {
-						fmt.Printf("compare \"%s\" to \"%s\"\n", k, h)
-					}
-					if k == h {
-						e := float32(2) * float32(1/float32(extra))
-						score += 1
-						escore += e
-
-					}
-				}
-				if *debug {
-					fmt.Printf("%s: %d\n", k, v)
-				}
-			}
-
+	for _, c := range commands {
+		if c.name == name {
+			os.Exit(c.run(args))
 		}
-		fmt.Printf("Score is %d (%.2f).\n", score, escore)
-		done <- true
-	}()
-	select {
-	case <-done:
-		break
-	case <-time.After(*timeout):
-		fmt.Printf("Timeout (%s)\n", *timeout)
 	}
-
+	errorf("unknown command %q", name)
+	usage()
+	os.Exit(exitUsage)
 }
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/hagna/scoreme/pkg/scoreme"
+)
+
+func init() {
+	var (
+		f     indexFlags
+		stamp bool
+	)
+	c := newCommand("migrate", "[newindex]", "Copy a boltbatch index, also one built by scoreme_db_batch, to a new file with the compact version 2 records, or stamp an index built before indexes kept metadata")
+	f.register(c.flags)
+	c.flags.BoolVar(&stamp, "stamp", false, "Record the -prefixlen, -algorithm and -splitlen the index was built with as its metadata, for an index built before indexes kept any. Scoring refuses such an index until then.")
+	c.run = func(args []string) int {
+		if code, ok := c.parse(args, 0, 1); !ok {
+			return code
+		}
+		// A newindex is only copied to without -stamp.
+		if stamp == (c.flags.NArg() == 1) {
+			c.flags.Usage()
+			return exitUsage
+		}
+		o, err := f.keyOptions()
+		if err != nil {
+			errorf("%s", err)
+			return exitUsage
+		}
+		if stamp {
+			m, err := scoreme.Stamp(f.backend, o)
+			if err != nil {
+				errorf("%s", err)
+				return exitFail
+			}
+			fmt.Printf("%s stamped as a version %d %s index of %d %s hashes with prefix length %d\n",
+				o.Path, m.Version, m.Backend, m.Records, m.Algorithm, m.PrefixLen)
+			return exitOK
+		}
+		if f.backend != "boltbatch" {
+			errorf("only boltbatch indexes have a newer record format")
+			return exitUsage
+		}
+		n, err := scoreme.Migrate(o, c.flags.Arg(0))
+		if err != nil {
+			errorf("%s", err)
+			return exitFail
+		}
+		fmt.Printf("%d records copied to %s\n", n, c.flags.Arg(0))
+		return exitOK
+	}
+}
@@ -0,0 +1,67 @@
+package scoreme
+
+import (
+	"crypto/sha1"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"unicode/utf16"
+
+	"golang.org/x/crypto/md4"
+)
+
+// Algorithm is the password hash function of an index.
+type Algorithm string
+
+const (
+	// SHA1 is the hash of the pwned passwords SHA-1 list.
+	SHA1 Algorithm = "sha1"
+	// NTLM is MD4 over the UTF-16LE password, as in the NTLM list.
+	NTLM Algorithm = "ntlm"
+)
+
+// Algorithms lists the known algorithms.
+var Algorithms = []Algorithm{SHA1, NTLM}
+
+// countWidth is the width of the space padded count in a sorted passwd
+// file.
+const countWidth = 20
+
+// ParseAlgorithm returns the algorithm with the given name.
+func ParseAlgorithm(s string) (Algorithm, error) {
+	for _, a := range Algorithms {
+		if string(a) == strings.ToLower(s) {
+			return a, nil
+		}
+	}
+	return "", fmt.Errorf("unknown hash algorithm %q", s)
+}
+
+// Size returns the length in bytes of a hash.
+func (a Algorithm) Size() int {
+	if a == NTLM {
+		return md4.Size
+	}
+	return sha1.Size
+}
+
+// RecordLen returns the length of a fixed width record: the raw hash, a
+// colon, a space padded count and a newline. For SHA1 it is RECORDLEN.
+func (a Algorithm) RecordLen() int {
+	return a.Size() + 1 + countWidth + 1
+}
+
+// Hash returns the uppercase hex hash of a candidate password.
+func (a Algorithm) Hash(p []byte) string {
+	if a != NTLM {
+		return Hash(p)
+	}
+	u := utf16.Encode([]rune(string(p)))
+	b := make([]byte, 2*len(u))
+	for i, c := range u {
+		binary.LittleEndian.PutUint16(b[2*i:], c)
+	}
+	h := md4.New()
+	h.Write(b)
+	return fmt.Sprintf("%X", h.Sum(nil))
+}
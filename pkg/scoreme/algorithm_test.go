@@ -0,0 +1,25 @@
+package scoreme
+
+import "testing"
+
+func TestAlgorithmHash(t *testing.T) {
+	tests := []struct {
+		a    Algorithm
+		pw   string
+		want string
+	}{
+		{SHA1, "password", "5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8"},
+		{SHA1, "", "DA39A3EE5E6B4B0D3255BFEF95601890AFD80709"},
+		{NTLM, "password", "8846F7EAEE8FB117AD06BDD830B7586C"},
+		{NTLM, "", "31D6CFE0D16AE931B73C59D7E0C089C0"},
+	}
+	for _, tc := range tests {
+		got := tc.a.Hash([]byte(tc.pw))
+		if got != tc.want {
+			t.Errorf("%s(%q) = %s, want %s", tc.a, tc.pw, got, tc.want)
+		}
+		if len(got) != 2*tc.a.Size() {
+			t.Errorf("%s(%q) is %d hex digits, want %d", tc.a, tc.pw, len(got), 2*tc.a.Size())
+		}
+	}
+}
@@ -0,0 +1,405 @@
+package scoreme
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/boltdb/bolt"
+)
+
+// Bolt is an Index stored in a bolt database. The key is the hash prefix
+// decoded to bytes and the value holds every record sharing that prefix:
+// the raw hash followed by ":count" and a newline.
+type Bolt struct {
+	opts Options
+	db   *bolt.DB
+	meta Meta
+}
+
+// metaBucket holds the Meta of each records bucket, keyed by bucket name.
+var metaBucket = []byte("meta")
+
+// openBolt opens the database at o.Path and returns it along with the Meta
+// of o.Bucket. With o.Create the bucket and its Meta are made if needed,
+// without it the database is opened read only.
+func openBolt(backend string, o Options) (*bolt.DB, Meta, error) {
+	// bolt makes the file even when opening it read only.
+	if !o.Create && !Exists(o.Path) {
+		return nil, Meta{}, fmt.Errorf("%s: no such index", o.Path)
+	}
+	db, err := bolt.Open(o.Path, 0644, &bolt.Options{ReadOnly: !o.Create})
+	if err != nil {
+		return nil, Meta{}, err
+	}
+	var m Meta
+	open := func(tx *bolt.Tx) error {
+		var stored *Meta
+		if mb := tx.Bucket(metaBucket); mb != nil {
+			if v := mb.Get([]byte(o.Bucket)); v != nil {
+				stored = &Meta{}
+				if err := json.Unmarshal(v, stored); err != nil {
+					return fmt.Errorf("%s: meta: %s", o.Path, err)
+				}
+			}
+		}
+		b := tx.Bucket([]byte(o.Bucket))
+		var err error
+		switch {
+		case stored != nil:
+			m, err = resolveMeta(backend, stored, o)
+		case b != nil && hasKeys(b):
+			m, err = stampedMeta(backend, o)
+		case o.Create:
+			m, err = resolveMeta(backend, nil, o)
+		default:
+			err = fmt.Errorf("there is no index in bucket %s", o.Bucket)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %s", o.Path, err)
+		}
+		if !o.Create {
+			return nil
+		}
+		if _, err := tx.CreateBucketIfNotExists([]byte(o.Bucket)); err != nil {
+			return fmt.Errorf("create bucket: %s", err)
+		}
+		if _, err := tx.CreateBucketIfNotExists(metaBucket); err != nil {
+			return fmt.Errorf("create bucket: %s", err)
+		}
+		// A stamped Meta is only saved by Stamp, after counting the records.
+		if stored == nil && !m.Stamped {
+			return putMeta(tx, m)
+		}
+		return nil
+	}
+	if o.Create {
+		err = db.Update(open)
+	} else {
+		err = db.View(open)
+	}
+	if err != nil {
+		db.Close()
+		return nil, Meta{}, err
+	}
+	return db, m, nil
+}
+
+// hasKeys reports whether the bucket holds any key.
+func hasKeys(b *bolt.Bucket) bool {
+	k, _ := b.Cursor().First()
+	return k != nil
+}
+
+func putMeta(tx *bolt.Tx, m Meta) error {
+	v, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(metaBucket).Put([]byte(m.Bucket), v)
+}
+
+// OpenBolt opens the bolt index at o.Path.
+func OpenBolt(o Options) (*Bolt, error) {
+	db, m, err := openBolt("bolt", o)
+	if err != nil {
+		return nil, err
+	}
+	o.PrefixLen = m.PrefixLen
+	return &Bolt{opts: o, db: db, meta: m}, nil
+}
+
+// Meta describes how the index was built.
+func (i *Bolt) Meta() Meta {
+	return i.meta
+}
+
+func (i *Bolt) setMeta(m Meta) error {
+	if err := i.db.Update(func(tx *bolt.Tx) error { return putMeta(tx, m) }); err != nil {
+		return err
+	}
+	i.meta = m
+	return nil
+}
+
+// boltKey returns the bucket key for the hex hash h.
+func boltKey(o Options, h string) ([]byte, error) {
+	if uint(len(h)) < o.PrefixLen {
+		return nil, fmt.Errorf("%s is shorter than the prefix length %d", h, o.PrefixLen)
+	}
+	return hex.DecodeString(h[:o.PrefixLen])
+}
+
+// binaryRecord converts a "HASH:count" line to the raw hash followed by
+// the untouched ":count" part.
+func binaryRecord(line string) ([]byte, error) {
+	i := strings.Index(line, ":")
+	if i == -1 {
+		return nil, fmt.Errorf("No \":\" in value \"%s\"", line)
+	}
+	bval, err := hex.DecodeString(line[:i])
+	if err != nil {
+		return nil, err
+	}
+	return append(bval, []byte(line[i:])...), nil
+}
+
+// get returns the value stored for the prefix of h.
+func get(db *bolt.DB, o Options, h string) ([]byte, error) {
+	var res []byte
+	bh, err := boltKey(o, h)
+	if err != nil {
+		return nil, err
+	}
+	err = db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(o.Bucket))
+		// The value is only valid for the life of the transaction.
+		res = append([]byte(nil), b.Get(bh)...)
+		if o.Debug {
+			log.Printf("found hash for key\n%s\nvalue\n%s\n", hex.Dump(bh), hex.Dump(res))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// Insert appends the record to the value for its prefix in its own
+// transaction.
+func (i *Bolt) Insert(line string) error {
+	line = strings.TrimSpace(line)
+	key, err := boltKey(i.opts, line)
+	if err != nil {
+		return err
+	}
+	bval, err := binaryRecord(line)
+	if err != nil {
+		return err
+	}
+	return i.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(i.opts.Bucket))
+		v := append(append([]byte(nil), b.Get(key)...), append(bval, '\n')...)
+		if i.opts.Debug {
+			log.Printf("Storing value \nkey\n%s\nvalue\n%s\n", hex.Dump(key), hex.Dump(v))
+		}
+		return b.Put(key, v)
+	})
+}
+
+// insertBatch appends the records of each group to the value of its key.
+func (i *Bolt) insertBatch(groups []group, m Meta) error {
+	err := i.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(i.opts.Bucket))
+		for _, g := range groups {
+			v := append(append([]byte(nil), b.Get(g.key)...), g.val...)
+			if err := b.Put(g.key, v); err != nil {
+				return err
+			}
+		}
+		return putMeta(tx, m)
+	})
+	if err != nil {
+		return err
+	}
+	i.meta = m
+	return nil
+}
+
+// records calls fn with the hex hash and count part of every record in a
+// Bolt value.
+func (i *Bolt) records(dat []byte, fn func(h string, count []byte) error) error {
+	return eachRecord(dat, i.meta.Algorithm.Size(), func(hash, count []byte) error {
+		return fn(strings.ToUpper(hex.EncodeToString(hash)), count)
+	})
+}
+
+// eachRecord calls fn with the raw hash and the count part of every
+// record in a Bolt value, with hashes size bytes long.
+func eachRecord(dat []byte, size int, fn func(hash, count []byte) error) error {
+	p := bufio.NewScanner(bytes.NewReader(dat))
+	p.Split(RecordSplitter(size))
+	for p.Scan() {
+		rec := p.Bytes()
+		j := bytes.LastIndex(rec, []byte(":"))
+		if j == -1 {
+			return fmt.Errorf("No \":\" found in stored value \n%s\n", hex.Dump(rec))
+		}
+		if err := fn(rec[:j], rec[j+1:]); err != nil {
+			return err
+		}
+	}
+	return p.Err()
+}
+
+// appendRecord appends a record of a Bolt value to v.
+func appendRecord(v, hash, count []byte) []byte {
+	v = append(v, hash...)
+	v = append(v, ':')
+	v = append(v, count...)
+	return append(v, '\n')
+}
+
+// merge adds the records of each group that are new to the value of its
+// key and replaces those whose count changed.
+func (i *Bolt) merge(groups []group, meta func(s BuildStats) Meta) error {
+	size := i.meta.Algorithm.Size()
+	var m Meta
+	err := i.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(i.opts.Bucket))
+		var s BuildStats
+		for _, g := range groups {
+			updates := make(map[string][]byte)
+			var order []string
+			err := eachRecord(g.val, size, func(hash, count []byte) error {
+				if _, ok := updates[string(hash)]; !ok {
+					order = append(order, string(hash))
+				}
+				// The scanner reuses its buffer.
+				updates[string(hash)] = append([]byte(nil), count...)
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			var v []byte
+			err = eachRecord(b.Get(g.key), size, func(hash, count []byte) error {
+				if c, ok := updates[string(hash)]; ok {
+					delete(updates, string(hash))
+					if sameCount(count, c) {
+						s.Unchanged++
+					} else {
+						s.Changed++
+						count = c
+					}
+				}
+				v = appendRecord(v, hash, count)
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			for _, h := range order {
+				if c, ok := updates[h]; ok {
+					s.Added++
+					v = appendRecord(v, []byte(h), c)
+				}
+			}
+			if err := b.Put(g.key, v); err != nil {
+				return err
+			}
+		}
+		m = meta(s)
+		return putMeta(tx, m)
+	})
+	if err != nil {
+		return err
+	}
+	i.meta = m
+	return nil
+}
+
+// errFound stops records once the wanted hash is found.
+var errFound = errors.New("found")
+
+// Lookup scans every record stored under the prefix of h.
+func (i *Bolt) Lookup(h string) (int, error) {
+	dat, err := get(i.db, i.opts, h)
+	if err != nil {
+		return 0, err
+	}
+	var count int
+	err = i.records(dat, func(k string, c []byte) error {
+		if i.opts.Debug {
+			log.Printf("compare \"%s\" to \"%s\"\n", h, k)
+		}
+		if k != h {
+			return nil
+		}
+		var err error
+		if count, err = parseCount(c); err != nil {
+			return err
+		}
+		return errFound
+	})
+	switch err {
+	case errFound:
+		return count, nil
+	case nil:
+		return 0, ErrNotFound
+	}
+	return 0, err
+}
+
+// Walk runs a cursor over the bucket.
+func (i *Bolt) Walk(fn func(h string, count int) error) error {
+	return i.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket([]byte(i.opts.Bucket)).Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			err := i.records(v, func(h string, c []byte) error {
+				count, err := parseCount(c)
+				if err != nil {
+					return err
+				}
+				return fn(h, count)
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Check checks that every value is a list of newline terminated records
+// with a whole hash, sharing the prefix of its key, none repeated.
+func (i *Bolt) Check(problem func(Problem)) (CheckResult, error) {
+	var res CheckResult
+	size := i.meta.Algorithm.Size()
+	err := i.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket([]byte(i.opts.Bucket)).Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			res.Keys++
+			kc := newKeyChecker(strings.ToUpper(hex.EncodeToString(k)), false, problem)
+			if uint(len(kc.key)) != i.meta.PrefixLen {
+				kc.fault("key is not %d hex characters long", i.meta.PrefixLen)
+			}
+			p := bufio.NewScanner(bytes.NewReader(v))
+			p.Split(RecordSplitter(size))
+			for p.Scan() {
+				res.Records++
+				rec := p.Bytes()
+				var count []byte
+				j := bytes.LastIndex(rec, []byte(":"))
+				if j == -1 {
+					j = len(rec)
+				} else {
+					count = rec[j+1:]
+				}
+				kc.record(strings.ToUpper(hex.EncodeToString(rec[:j])), count)
+				if j != size {
+					kc.fault("hash is %d bytes, want %d", j, size)
+				}
+			}
+			if err := p.Err(); err != nil {
+				return err
+			}
+			if len(v) > 0 && v[len(v)-1] != '\n' {
+				kc.fault("last record has no newline")
+			}
+		}
+		return nil
+	})
+	return res, err
+}
+
+// Close closes the database.
+func (i *Bolt) Close() error {
+	return i.db.Close()
+}
@@ -0,0 +1,489 @@
+package scoreme
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// BoltBatch is an Index stored in a bolt database like Bolt, but built
+// from a passwd file that is sorted by hash. Records sharing a prefix are
+// collected and written in one transaction, and since they have a fixed
+// width, see recordLayout, a lookup is a binary search.
+type BoltBatch struct {
+	opts       Options
+	db         *bolt.DB
+	meta       Meta
+	buf        []byte
+	currentkey string
+}
+
+// OpenBoltBatch opens the bolt index at o.Path.
+func OpenBoltBatch(o Options) (*BoltBatch, error) {
+	db, m, err := openBolt("boltbatch", o)
+	if err != nil {
+		return nil, err
+	}
+	o.PrefixLen = m.PrefixLen
+	return &BoltBatch{opts: o, db: db, meta: m}, nil
+}
+
+// Meta describes how the index was built.
+func (i *BoltBatch) Meta() Meta {
+	return i.meta
+}
+
+func (i *BoltBatch) setMeta(m Meta) error {
+	if err := i.db.Update(func(tx *bolt.Tx) error { return putMeta(tx, m) }); err != nil {
+		return err
+	}
+	i.meta = m
+	return nil
+}
+
+// checkpoint writes out the buffered prefix along with m, so the
+// checkpoint in m never runs ahead of the records.
+func (i *BoltBatch) checkpoint(m Meta) error {
+	if err := i.write(func(tx *bolt.Tx) error { return putMeta(tx, m) }); err != nil {
+		return err
+	}
+	i.meta = m
+	return nil
+}
+
+// insertBatch writes each group as the whole value of its key, the
+// groups holding every record of their prefix.
+func (i *BoltBatch) insertBatch(groups []group, m Meta) error {
+	l := i.layout()
+	err := i.write(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(i.opts.Bucket))
+		for _, g := range groups {
+			v, err := l.records(g.val)
+			if err != nil {
+				return fmt.Errorf("prefix %s: %s", g.prefix, err)
+			}
+			if err := b.Put(g.key, v); err != nil {
+				return err
+			}
+		}
+		return putMeta(tx, m)
+	})
+	if err != nil {
+		return err
+	}
+	i.meta = m
+	return nil
+}
+
+// merge joins the sorted records of each group with those stored under its
+// key, taking the new record where a hash is in both.
+func (i *BoltBatch) merge(groups []group, meta func(s BuildStats) Meta) error {
+	l := i.layout()
+	var m Meta
+	err := i.write(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(i.opts.Bucket))
+		var s BuildStats
+		for _, g := range groups {
+			upd, err := l.records(g.val)
+			if err != nil {
+				return fmt.Errorf("prefix %s: %s", g.prefix, err)
+			}
+			old := b.Get(g.key)
+			if len(old)%l.reclen != 0 {
+				return fmt.Errorf("prefix %s: the stored records are broken, see scoreme verify", g.prefix)
+			}
+			v := make([]byte, 0, len(old)+len(upd))
+			for len(old) > 0 || len(upd) > 0 {
+				c := -1
+				switch {
+				case len(old) == 0:
+					c = 1
+				case len(upd) > 0:
+					c = bytes.Compare(old[:l.hashLen], upd[:l.hashLen])
+				}
+				switch {
+				case c < 0:
+					v = append(v, old[:l.reclen]...)
+					old = old[l.reclen:]
+					continue
+				case c > 0:
+					s.Added++
+				case l.sameCount(old[:l.reclen], upd[:l.reclen]):
+					s.Unchanged++
+				default:
+					s.Changed++
+				}
+				v = append(v, upd[:l.reclen]...)
+				upd = upd[l.reclen:]
+				if c == 0 {
+					old = old[l.reclen:]
+				}
+			}
+			if err := b.Put(g.key, v); err != nil {
+				return err
+			}
+		}
+		m = meta(s)
+		return putMeta(tx, m)
+	})
+	if err != nil {
+		return err
+	}
+	i.meta = m
+	return nil
+}
+
+func (i *BoltBatch) flush() error {
+	return i.write(nil)
+}
+
+// write puts the buffered prefix and then runs fn, if not nil, in one
+// transaction.
+func (i *BoltBatch) write(fn func(tx *bolt.Tx) error) error {
+	if i.currentkey == "" && fn == nil {
+		return nil
+	}
+	err := i.db.Update(func(tx *bolt.Tx) error {
+		if i.currentkey != "" {
+			key, err := boltKey(i.opts, i.currentkey)
+			if err != nil {
+				return err
+			}
+			if err := tx.Bucket([]byte(i.opts.Bucket)).Put(key, i.buf); err != nil {
+				return err
+			}
+		}
+		if fn != nil {
+			return fn(tx)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	i.buf, i.currentkey = []byte{}, ""
+	return nil
+}
+
+// Insert buffers the record, writing out the previous prefix when the
+// prefix changes. Lines must arrive sorted by hash.
+func (i *BoltBatch) Insert(line string) error {
+	key, err := boltKey(i.opts, line)
+	if err != nil {
+		return err
+	}
+	if i.opts.Debug {
+		log.Printf("Insert key is %X\n", key)
+	}
+	if line[:i.opts.PrefixLen] != i.currentkey {
+		if err := i.flush(); err != nil {
+			return err
+		}
+	}
+	i.currentkey = line[:i.opts.PrefixLen]
+	j := strings.Index(line, ":")
+	if j == -1 {
+		return fmt.Errorf("No \":\" in value \"%s\"", line)
+	}
+	hash, err := hex.DecodeString(line[:j])
+	if err != nil {
+		return err
+	}
+	rec, err := i.layout().encode(hash, []byte(line[j+1:]))
+	if err != nil {
+		return err
+	}
+	i.buf = append(i.buf, rec...)
+	return nil
+}
+
+// sortedInput marks that Insert needs the lines sorted by hash, which
+// Build sees to.
+func (i *BoltBatch) sortedInput() {}
+
+// Lookup binary searches the records stored under the prefix of h,
+// comparing raw hashes.
+func (i *BoltBatch) Lookup(h string) (int, error) {
+	l := i.layout()
+	raw, err := hex.DecodeString(h)
+	if err != nil {
+		return 0, err
+	}
+	if len(raw) != i.meta.Algorithm.Size() {
+		return 0, ErrNotFound
+	}
+	dat, err := get(i.db, i.opts, h)
+	if err != nil {
+		return 0, err
+	}
+	want := raw[l.skip:]
+	n := len(dat) / l.reclen
+	j := sort.Search(n, func(j int) bool {
+		return bytes.Compare(dat[j*l.reclen:j*l.reclen+l.hashLen], want) >= 0
+	})
+	if j == n {
+		return 0, ErrNotFound
+	}
+	rec := dat[j*l.reclen : (j+1)*l.reclen]
+	if !bytes.Equal(rec[:l.hashLen], want) {
+		return 0, ErrNotFound
+	}
+	return l.count(rec)
+}
+
+// readKey returns the records under the key of h, which are sorted.
+func (i *BoltBatch) readKey(h string) ([]keyRecord, error) {
+	l := i.layout()
+	key, err := boltKey(i.opts, h)
+	if err != nil {
+		return nil, err
+	}
+	dat, err := get(i.db, i.opts, h)
+	if err != nil {
+		return nil, err
+	}
+	recs := make([]keyRecord, 0, len(dat)/l.reclen)
+	for j := 0; j+l.reclen <= len(dat); j += l.reclen {
+		rec := dat[j : j+l.reclen]
+		count, err := l.count(rec)
+		if err != nil {
+			return nil, err
+		}
+		raw := append(append(make([]byte, 0, l.size), key[:l.skip]...), rec[:l.hashLen]...)
+		recs = append(recs, keyRecord{raw, count})
+	}
+	return recs, nil
+}
+
+// Walk runs a cursor over the bucket.
+func (i *BoltBatch) Walk(fn func(h string, count int) error) error {
+	l := i.layout()
+	return i.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket([]byte(i.opts.Bucket)).Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			for j := 0; j+l.reclen <= len(v); j += l.reclen {
+				rec := v[j : j+l.reclen]
+				count, err := l.count(rec)
+				if err != nil {
+					return err
+				}
+				if err := fn(l.hash(k, rec), count); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// Check checks that every value is a whole number of records, sorted and
+// sharing the prefix of its key, and that version 1 records have a colon
+// and a newline in place.
+func (i *BoltBatch) Check(problem func(Problem)) (CheckResult, error) {
+	var res CheckResult
+	l := i.layout()
+	err := i.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket([]byte(i.opts.Bucket)).Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			res.Keys++
+			kc := newKeyChecker(strings.ToUpper(hex.EncodeToString(k)), true, problem)
+			if uint(len(kc.key)) != i.meta.PrefixLen {
+				kc.fault("key is not %d hex characters long", i.meta.PrefixLen)
+				continue
+			}
+			if len(v)%l.reclen != 0 {
+				kc.fault("value is %d bytes, not a whole number of %d byte records", len(v), l.reclen)
+			}
+			for j := 0; j+l.reclen <= len(v); j += l.reclen {
+				res.Records++
+				rec := v[j : j+l.reclen]
+				if l.v2 {
+					n, _ := l.count(rec)
+					kc.record(l.hash(k, rec), []byte(strconv.Itoa(n)))
+					continue
+				}
+				kc.record(l.hash(k, rec), rec[l.hashLen+1:])
+				if rec[l.hashLen] != ':' || rec[l.reclen-1] != '\n' {
+					kc.fault("record is not hash:count and a newline")
+				}
+			}
+		}
+		return nil
+	})
+	return res, err
+}
+
+// Close writes out the last prefix and closes the database.
+func (i *BoltBatch) Close() error {
+	err := i.flush()
+	if cerr := i.db.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// recordLayout is where the hash and the count sit in the fixed width
+// records of a BoltBatch value. Version 1 records are the raw hash, a
+// colon, a space padded count and a newline. Version 2 records leave out
+// the hash bytes already in the key and end with the count as a big endian
+// uint32.
+type recordLayout struct {
+	v2 bool
+	// size is the hash size, skip the number of its bytes left out and
+	// hashLen the number kept.
+	size, skip, hashLen int
+	reclen              int
+}
+
+func (i *BoltBatch) layout() recordLayout {
+	size := i.meta.Algorithm.Size()
+	if i.meta.Version < 2 {
+		return recordLayout{size: size, hashLen: size, reclen: i.meta.Algorithm.RecordLen()}
+	}
+	skip := int(i.meta.PrefixLen / 2)
+	return recordLayout{v2: true, size: size, skip: skip, hashLen: size - skip, reclen: size - skip + 4}
+}
+
+// hash returns the uppercase hex hash of the record rec stored under key.
+func (l recordLayout) hash(key, rec []byte) string {
+	h := rec[:l.hashLen]
+	if l.skip > 0 {
+		h = append(append([]byte(nil), key[:l.skip]...), h...)
+	}
+	return strings.ToUpper(hex.EncodeToString(h))
+}
+
+// count returns the count of the record rec.
+func (l recordLayout) count(rec []byte) (int, error) {
+	if l.v2 {
+		return int(binary.BigEndian.Uint32(rec[l.hashLen:])), nil
+	}
+	return parseCount(rec[l.hashLen+1:])
+}
+
+func (l recordLayout) sameCount(a, b []byte) bool {
+	if l.v2 {
+		return bytes.Equal(a[l.hashLen:], b[l.hashLen:])
+	}
+	return sameCount(a[l.hashLen+1:], b[l.hashLen+1:])
+}
+
+// encode returns the record of the raw hash and the count part of a passwd
+// line.
+func (l recordLayout) encode(hash, count []byte) ([]byte, error) {
+	if len(hash) != l.size {
+		return nil, fmt.Errorf("hash is %d bytes, want %d", len(hash), l.size)
+	}
+	if !l.v2 {
+		return appendRecord(nil, hash, count), nil
+	}
+	n, err := parseCount(count)
+	if err != nil {
+		return nil, err
+	}
+	if n < 0 || n > math.MaxUint32 {
+		return nil, fmt.Errorf("count %d does not fit in a record", n)
+	}
+	rec := make([]byte, l.reclen)
+	copy(rec, hash[l.skip:])
+	binary.BigEndian.PutUint32(rec[l.hashLen:], uint32(n))
+	return rec, nil
+}
+
+// records returns the records of a group laid out as a Bolt value.
+func (l recordLayout) records(val []byte) ([]byte, error) {
+	if !l.v2 {
+		if len(val)%l.reclen != 0 {
+			return nil, fmt.Errorf("records must be %d bytes, pad the counts to %d characters", l.reclen, countWidth)
+		}
+		return val, nil
+	}
+	res := make([]byte, 0, len(val))
+	err := eachRecord(val, l.size, func(hash, count []byte) error {
+		rec, err := l.encode(hash, count)
+		res = append(res, rec...)
+		return err
+	})
+	return res, err
+}
+
+// Migrate copies the BoltBatch index at o.Path to a new one at path with
+// version 2 records, returning the number of records copied. An index
+// built before indexes kept a Meta is taken to be keyed as o says.
+func Migrate(o Options, path string) (int64, error) {
+	o.Create, o.Stamp = false, true
+	src, err := OpenBoltBatch(o)
+	if err != nil {
+		return 0, err
+	}
+	defer src.Close()
+	m := src.Meta()
+	if m.Version >= FormatVersion {
+		return 0, fmt.Errorf("%s already has version %d records", o.Path, m.Version)
+	}
+	if m.Checkpoint != nil {
+		return 0, fmt.Errorf("%s has an unfinished build, resume it first", o.Path)
+	}
+	if Exists(path) {
+		return 0, fmt.Errorf("%s already exists", path)
+	}
+	srcPath := o.Path
+	o.Path, o.PrefixLen, o.Algorithm, o.Create, o.Stamp = path, m.PrefixLen, m.Algorithm, true, false
+	dst, err := OpenBoltBatch(o)
+	if err != nil {
+		return 0, err
+	}
+	defer dst.Close()
+	from := src.layout()
+	var n int64
+	var groups []group
+	copyGroups := func() error {
+		err := dst.insertBatch(groups, dst.Meta())
+		groups = groups[:0]
+		return err
+	}
+	err = src.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket([]byte(o.Bucket)).Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			g := group{prefix: strings.ToUpper(hex.EncodeToString(k)), key: append([]byte(nil), k...)}
+			for j := 0; j+from.reclen <= len(v); j += from.reclen {
+				rec := v[j : j+from.reclen]
+				count, err := from.count(rec)
+				if err != nil {
+					return err
+				}
+				// insertBatch takes the records laid out as a Bolt value.
+				hash, _ := hex.DecodeString(from.hash(k, rec))
+				g.val = appendRecord(g.val, hash, []byte(strconv.Itoa(count)))
+				n++
+			}
+			groups = append(groups, g)
+			if len(groups) >= 1000 {
+				if err := copyGroups(); err != nil {
+					return err
+				}
+			}
+		}
+		return copyGroups()
+	})
+	if err != nil {
+		return n, err
+	}
+	// The new index is a finished build of its own, counted as it was
+	// copied. It keeps the passwd file of the old one, if that was known.
+	m.Version, m.Records = dst.Meta().Version, n
+	m.Built, m.Stamped = time.Now().UTC(), false
+	if m.Source == nil {
+		m.Source = &Source{Name: "migrated from " + srcPath}
+	}
+	return n, dst.setMeta(m)
+}
@@ -0,0 +1,137 @@
+package scoreme
+
+import (
+	"encoding/hex"
+	"fmt"
+	"math"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/boltdb/bolt"
+)
+
+func TestRecordLayout(t *testing.T) {
+	h := Hash([]byte("password"))
+	raw, _ := hex.DecodeString(h)
+	for _, version := range []int{1, 2} {
+		i := &BoltBatch{meta: Meta{Version: version, Algorithm: SHA1, PrefixLen: 4}}
+		l := i.layout()
+		count := []byte("42")
+		if version == 1 {
+			count = []byte(fmt.Sprintf("%*d", countWidth, 42))
+		}
+		rec, err := l.encode(raw, count)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(rec) != l.reclen {
+			t.Errorf("version %d: the record is %d bytes, want %d", version, len(rec), l.reclen)
+		}
+		if got := l.hash(raw[:2], rec); got != h {
+			t.Errorf("version %d: got hash %s, want %s", version, got, h)
+		}
+		if n, err := l.count(rec); err != nil || n != 42 {
+			t.Errorf("version %d: got count %d, %v, want 42", version, n, err)
+		}
+		if _, err := l.encode(raw[1:], count); err == nil {
+			t.Errorf("version %d: a short hash was encoded", version)
+		}
+	}
+	l := (&BoltBatch{meta: Meta{Version: 2, Algorithm: SHA1, PrefixLen: 4}}).layout()
+	if l.reclen != 20-2+4 {
+		t.Errorf("a version 2 record is %d bytes, want %d", l.reclen, 20-2+4)
+	}
+	if _, err := l.encode(raw, []byte(fmt.Sprint(int64(math.MaxUint32)+1))); err == nil {
+		t.Error("a count over 32 bits was encoded")
+	}
+}
+
+// writeV1 writes the records of idx to a bolt database at path the way
+// scoreme_db_batch did: version 1 records keyed on a prefix of 4 and no
+// Meta.
+func writeV1(t *testing.T, path string, idx memIndex) {
+	t.Helper()
+	db, err := bolt.Open(path, 0644, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	var hashes []string
+	for h := range idx {
+		hashes = append(hashes, h)
+	}
+	// Uppercase hex sorts as the raw hashes do.
+	sort.Strings(hashes)
+	err = db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("bucket1"))
+		if err != nil {
+			return err
+		}
+		for _, h := range hashes {
+			key, _ := hex.DecodeString(h[:4])
+			raw, _ := hex.DecodeString(h)
+			v := append([]byte(nil), b.Get(key)...)
+			v = appendRecord(v, raw, []byte(fmt.Sprintf("%*d", countWidth, idx[h])))
+			if err := b.Put(key, v); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	dir := t.TempDir()
+	mem := testIndex()
+	v1 := filepath.Join(dir, "v1.db")
+	writeV1(t, v1, mem)
+	o := Options{Path: v1, Bucket: "bucket1", PrefixLen: 4}
+	if _, err := OpenBoltBatch(o); err == nil || !strings.Contains(err.Error(), "no metadata") {
+		t.Fatalf("an index without metadata opened, error %v", err)
+	}
+
+	v2 := filepath.Join(dir, "v2.db")
+	n, err := Migrate(o, v2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(len(mem)) {
+		t.Errorf("%d records copied, want %d", n, len(mem))
+	}
+	o.Stamp = true
+	src, err := OpenBoltBatch(o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+	dst, err := OpenBoltBatch(Options{Path: v2, Bucket: "bucket1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dst.Close()
+
+	m := dst.Meta()
+	if m.Version != 2 || m.Records != int64(len(mem)) || m.Stamped || m.Built.IsZero() || m.Source == nil {
+		t.Errorf("the migrated index has Meta %+v", m)
+	}
+	hashes := []string{Hash([]byte("not in the index"))}
+	for h := range mem {
+		hashes = append(hashes, h)
+	}
+	for _, h := range hashes {
+		want, werr := src.Lookup(h)
+		got, gerr := dst.Lookup(h)
+		if got != want || gerr != werr || (werr == nil && want != mem[h]) {
+			t.Errorf("%s: version 1 has %d, %v, version 2 %d, %v", h, want, werr, got, gerr)
+		}
+	}
+	var problems []string
+	if _, err := Check(dst, func(p Problem) { problems = append(problems, p.String()) }); err != nil || len(problems) > 0 {
+		t.Errorf("check: %v %q", err, problems)
+	}
+}
@@ -0,0 +1,606 @@
+package scoreme
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Checkpoint records how far an unfinished build got, so it can be
+// resumed.
+type Checkpoint struct {
+	// Name, Size and ModTime identify the passwd file.
+	Name    string    `json:"name"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modtime"`
+	// Offset is where the first line not yet in the index starts.
+	Offset int64 `json:"offset"`
+	// Prefix is the prefix of the last line before Offset. Every record
+	// with that prefix is in the index.
+	Prefix  string `json:"prefix"`
+	Records int64  `json:"records"`
+	// Merge is set for a merge, and Stats counts what the build did so
+	// far.
+	Merge bool       `json:"merge,omitempty"`
+	Stats BuildStats `json:"stats"`
+	// Hash is the state of the SHA-256 of the file up to Offset.
+	Hash []byte    `json:"hash"`
+	Time time.Time `json:"time"`
+}
+
+// BuildStats counts what a Build did to the records of the index. Without
+// merging every record is added.
+type BuildStats struct {
+	Added     int64 `json:"added"`
+	Changed   int64 `json:"changed"`
+	Unchanged int64 `json:"unchanged"`
+}
+
+func (s *BuildStats) add(t BuildStats) {
+	s.Added += t.Added
+	s.Changed += t.Changed
+	s.Unchanged += t.Unchanged
+}
+
+// Progress is passed to the progress func of Build after each batch.
+type Progress struct {
+	// Lines, Bytes long, were inserted in the Elapsed time since the last
+	// report.
+	Lines   int
+	Bytes   int64
+	Elapsed time.Duration
+	// Offset of the Size bytes of the passwd file are done, Running after
+	// the build started at Start.
+	Offset, Size, Start int64
+	Running             time.Duration
+}
+
+// Percent is how much of the passwd file is done.
+func (p Progress) Percent() float64 {
+	if p.Size == 0 {
+		return 100
+	}
+	return 100 * float64(p.Offset) / float64(p.Size)
+}
+
+// Rate is the number of lines and bytes inserted per second since the
+// last report.
+func (p Progress) Rate() (lines, bytes float64) {
+	sec := p.Elapsed.Seconds()
+	if sec <= 0 {
+		return 0, 0
+	}
+	return float64(p.Lines) / sec, float64(p.Bytes) / sec
+}
+
+// ETA estimates the time left from the rate so far.
+func (p Progress) ETA() time.Duration {
+	done := p.Offset - p.Start
+	if done <= 0 {
+		return 0
+	}
+	return time.Duration(float64(p.Running) * float64(p.Size-p.Offset) / float64(done))
+}
+
+// BuildOptions control Build.
+type BuildOptions struct {
+	// BatchSize is the number of lines between progress reports and
+	// checkpoints.
+	BatchSize int
+	// Progress, if not nil, is called after every batch.
+	Progress func(p Progress)
+	// Resume continues the unfinished build recorded in the index.
+	Resume bool
+	// Merge merges the passwd file into the records of the index, adding
+	// new hashes and updating the count of those it holds, rather than
+	// inserting every line as a new record.
+	Merge bool
+	// Workers is the number of goroutines parsing lines for an index that
+	// can write a batch of records at once. With 0 every line is
+	// inserted in turn.
+	Workers int
+	// TempDir is where an unsorted passwd file is sorted for an index
+	// that needs sorted input, by default os.TempDir().
+	TempDir string
+	// SortMemory is roughly how many bytes of lines are sorted in memory
+	// at a time, by default 256MB.
+	SortMemory int64
+}
+
+// checkpointer is implemented by indexes that buffer records, so a
+// checkpoint has to be written along with them.
+type checkpointer interface {
+	// checkpoint writes out the buffered records and m together.
+	checkpoint(m Meta) error
+}
+
+// batcher is implemented by indexes that can write many records at once.
+type batcher interface {
+	// insertBatch adds the records of every group and writes m in one
+	// transaction.
+	insertBatch(groups []group, m Meta) error
+}
+
+// merger is implemented by indexes that can merge records into those
+// they hold.
+type merger interface {
+	// merge merges the records of every group, then writes the Meta
+	// returned by meta for what the merge did, in one go where it can.
+	merge(groups []group, meta func(s BuildStats) Meta) error
+}
+
+// group is the records sharing one prefix, laid out as a Bolt value. The
+// key is the prefix decoded, when it has an even length.
+type group struct {
+	prefix string
+	key    []byte
+	val    []byte
+}
+
+// chunk is a run of whole lines of the passwd file, ending where a new
+// prefix starts.
+type chunk struct {
+	seq int
+	// offset is where data starts in the file.
+	offset int64
+	data   []byte
+	lines  int
+	// cp is the checkpoint after the chunk.
+	cp     Checkpoint
+	groups []group
+	err    error
+}
+
+// builder reads the passwd file of a Build in chunks.
+type builder struct {
+	idx  Index
+	o    BuildOptions
+	meta Meta
+	p    *bufio.Scanner
+	// raw is the line last scanned, with its line ending, and held is
+	// a line scanned but left for the next chunk.
+	raw, held []byte
+	h         hash.Hash
+	cp        Checkpoint
+	// size is the size of the file read, which is a sorted copy of the
+	// passwd file if it had to be sorted.
+	size int64
+	// records and stats are updated as chunks are written.
+	records int64
+	stats   BuildStats
+}
+
+// Build inserts the lines of the passwd file at path into idx, then
+// records the file, the number of records and the time in the index
+// metadata. The file is read in batches, each ending at the start of a new
+// prefix so no prefix is left half written, and a checkpoint is saved
+// after every batch which lets an interrupted build be resumed.
+func Build(idx Index, path string, o BuildOptions) (BuildStats, error) {
+	b := &builder{idx: idx, o: o, meta: idx.Meta(), h: sha256.New()}
+	err := b.build(path)
+	return b.stats, err
+}
+
+func (b *builder) build(path string) error {
+	idx, o := b.idx, b.o
+	w, ok := idx.(metaWriter)
+	if !ok {
+		return fmt.Errorf("the %s backend can't record a build", b.meta.Backend)
+	}
+	mg, ok := idx.(merger)
+	if o.Merge && !ok {
+		return fmt.Errorf("the %s backend can't merge", b.meta.Backend)
+	}
+	fh, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer fh.Close()
+	fi, err := fh.Stat()
+	if err != nil {
+		return err
+	}
+	b.cp = Checkpoint{
+		Name:    filepath.Base(path),
+		Size:    fi.Size(),
+		ModTime: fi.ModTime().UTC(),
+		Records: b.meta.Records,
+		Merge:   o.Merge,
+	}
+	switch last := b.meta.Checkpoint; {
+	case o.Resume && last == nil:
+		return errors.New("there is no unfinished build to resume")
+	case o.Resume:
+		if last.Name != b.cp.Name || last.Size != b.cp.Size || !last.ModTime.Equal(b.cp.ModTime) {
+			return fmt.Errorf("the unfinished build is of %s (%d bytes, modified %s), not of this file",
+				last.Name, last.Size, last.ModTime.Format(time.RFC3339))
+		}
+		if last.Merge != o.Merge {
+			kind := "build"
+			if last.Merge {
+				kind = "merge"
+			}
+			return fmt.Errorf("the unfinished %s has to be resumed as a %s", kind, kind)
+		}
+		b.cp = *last
+		b.stats = last.Stats
+		if err := b.h.(encoding.BinaryUnmarshaler).UnmarshalBinary(b.cp.Hash); err != nil {
+			return fmt.Errorf("checkpoint: %s", err)
+		}
+	case last != nil:
+		return fmt.Errorf("the build of %s stopped at byte %d of %d, resume it first", last.Name, last.Offset, last.Size)
+	}
+
+	// An index that needs sorted input reads a sorted copy of an unsorted
+	// file. Sorting gives the same copy every time, so the offsets of a
+	// checkpoint still hold when it is sorted again to resume.
+	src, sum := fh, ""
+	b.size = fi.Size()
+	if _, ok := idx.(sortedInput); ok {
+		var sorted bool
+		if sorted, sum, err = checkSorted(fh); err != nil {
+			return err
+		}
+		if !sorted {
+			name, dir, err := sortFile(path, o.TempDir, o.SortMemory)
+			if dir != "" {
+				defer os.RemoveAll(dir)
+			}
+			if err != nil {
+				return fmt.Errorf("sort %s: %s", path, err)
+			}
+			if src, err = os.Open(name); err != nil {
+				return err
+			}
+			defer src.Close()
+			if fi, err = src.Stat(); err != nil {
+				return err
+			}
+			b.size = fi.Size()
+		}
+	}
+	if _, err := src.Seek(b.cp.Offset, io.SeekStart); err != nil {
+		return err
+	}
+	b.p = bufio.NewScanner(src)
+	b.p.Split(func(data []byte, atEOF bool) (int, []byte, error) {
+		advance, token, err := bufio.ScanLines(data, atEOF)
+		b.raw = data[:advance]
+		return advance, token, err
+	})
+
+	cw, exact := idx.(checkpointer)
+	save := func(m Meta) error {
+		if exact {
+			return cw.checkpoint(m)
+		}
+		return w.setMeta(m)
+	}
+	// A new build saves its checkpoint before the first line, so it can be
+	// resumed even if it stops before the first batch is done.
+	if !o.Resume {
+		state, err := b.h.(encoding.BinaryMarshaler).MarshalBinary()
+		if err != nil {
+			return err
+		}
+		cp := b.cp
+		cp.Hash, cp.Time = state, time.Now().UTC()
+		m := idx.Meta()
+		m.Checkpoint = &cp
+		if err := save(m); err != nil {
+			return err
+		}
+	}
+	b.records = b.cp.Records
+	bw, ok := idx.(batcher)
+	switch {
+	case o.Merge:
+		// Merging only reads and writes the index in the writer, so it
+		// goes through the workers even when there are none to spare.
+		if b.o.Workers <= 0 {
+			b.o.Workers = 1
+		}
+		err = b.parallel(func(c *chunk) error {
+			return mg.merge(c.groups, func(s BuildStats) Meta { return b.commit(c, s) })
+		})
+	case ok && o.Workers > 0:
+		err = b.parallel(func(c *chunk) error {
+			return bw.insertBatch(c.groups, b.commit(c, BuildStats{Added: int64(c.lines)}))
+		})
+	default:
+		err = b.serial(save, !exact)
+	}
+	if err != nil {
+		return err
+	}
+	if sum == "" {
+		sum = hex.EncodeToString(b.h.Sum(nil))
+	}
+	m := idx.Meta()
+	m.Records = b.records
+	m.Source = &Source{
+		Name:   b.cp.Name,
+		Size:   b.cp.Size,
+		SHA256: sum,
+	}
+	m.Built = time.Now().UTC()
+	m.Checkpoint = nil
+	return save(m)
+}
+
+// next returns the next line of the file, with its line ending, without
+// taking it.
+func (b *builder) next() ([]byte, error) {
+	if b.held == nil {
+		if !b.p.Scan() {
+			return nil, b.p.Err()
+		}
+		b.held = b.raw
+	}
+	return b.held, nil
+}
+
+// line returns raw without its line ending and its prefix.
+func (b *builder) line(raw []byte) (l, prefix string) {
+	l = strings.TrimRight(string(raw), "\r\n")
+	prefix = l
+	if uint(len(l)) >= b.meta.PrefixLen {
+		prefix = l[:b.meta.PrefixLen]
+	}
+	return l, prefix
+}
+
+// readChunk returns the next chunk of at least BatchSize lines, or nil at
+// the end of the file.
+func (b *builder) readChunk(seq int) (*chunk, error) {
+	c := &chunk{seq: seq, offset: b.cp.Offset}
+	for {
+		raw, err := b.next()
+		if err != nil {
+			return nil, err
+		}
+		if raw == nil {
+			break
+		}
+		l, prefix := b.line(raw)
+		if l != "" {
+			if c.lines >= b.o.BatchSize && prefix != b.cp.Prefix {
+				break
+			}
+			c.lines++
+			b.cp.Prefix = prefix
+		}
+		c.data = append(c.data, raw...)
+		b.h.Write(raw)
+		b.cp.Offset += int64(len(raw))
+		b.held = nil
+	}
+	if len(c.data) == 0 {
+		return nil, nil
+	}
+	state, err := b.h.(encoding.BinaryMarshaler).MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	c.cp = b.cp
+	c.cp.Hash, c.cp.Time = state, time.Now().UTC()
+	return c, nil
+}
+
+// each calls fn with every line of c that is not blank and its offset.
+func (c *chunk) each(fn func(l string, offset int64) error) error {
+	data, offset := c.data, c.offset
+	for len(data) > 0 {
+		n := bytes.IndexByte(data, '\n') + 1
+		if n == 0 {
+			n = len(data)
+		}
+		if l := strings.TrimRight(string(data[:n]), "\r\n"); l != "" {
+			if err := fn(l, offset); err != nil {
+				return fmt.Errorf("byte %d: %s", offset, err)
+			}
+		}
+		data, offset = data[n:], offset+int64(n)
+	}
+	return nil
+}
+
+// parse groups the records of c by prefix.
+func (b *builder) parse(c *chunk) error {
+	n := int(b.meta.PrefixLen)
+	prefixes := make(map[string]int)
+	return c.each(func(l string, offset int64) error {
+		if len(l) < n {
+			return fmt.Errorf("%s is shorter than the prefix length %d", l, n)
+		}
+		rec, err := binaryRecord(l)
+		if err != nil {
+			return err
+		}
+		prefix := strings.ToUpper(l[:n])
+		j, ok := prefixes[prefix]
+		if !ok {
+			g := group{prefix: prefix}
+			if n%2 == 0 {
+				if g.key, err = hex.DecodeString(prefix); err != nil {
+					return err
+				}
+			}
+			j = len(c.groups)
+			prefixes[prefix] = j
+			c.groups = append(c.groups, g)
+		}
+		c.groups[j].val = append(append(c.groups[j].val, rec...), '\n')
+		return nil
+	})
+}
+
+// progress tracks the reports of a Build.
+type progress struct {
+	Progress
+	start, last time.Time
+}
+
+func (b *builder) newProgress() *progress {
+	now := time.Now()
+	return &progress{
+		Progress: Progress{Offset: b.cp.Offset, Size: b.size, Start: b.cp.Offset},
+		start:    now,
+		last:     now,
+	}
+}
+
+// report reports c as written.
+func (p *progress) report(c *chunk, fn func(Progress)) {
+	if fn == nil {
+		return
+	}
+	now := time.Now()
+	p.Lines, p.Bytes = c.lines, int64(len(c.data))
+	p.Elapsed, p.Running = now.Sub(p.last), now.Sub(p.start)
+	p.Offset = c.cp.Offset
+	p.last = now
+	fn(p.Progress)
+}
+
+// serial inserts the lines one by one, saving a checkpoint after every
+// chunk. With catchup, lines of a resumed build that are already in the
+// index are skipped up to the first that is not, since an index that
+// writes its records apart from the checkpoint may hold some past it.
+func (b *builder) serial(save func(Meta) error, catchup bool) error {
+	catchup = catchup && b.o.Resume
+	prog := b.newProgress()
+	for seq := 0; ; seq++ {
+		c, err := b.readChunk(seq)
+		if err != nil || c == nil {
+			return err
+		}
+		err = c.each(func(l string, offset int64) error {
+			if catchup {
+				hash := l
+				if i := strings.Index(l, ":"); i != -1 {
+					hash = l[:i]
+				}
+				switch _, err := b.idx.Lookup(strings.ToUpper(hash)); err {
+				case nil:
+					return nil
+				case ErrNotFound:
+					catchup = false
+				default:
+					return err
+				}
+			}
+			return b.idx.Insert(l)
+		})
+		if err != nil {
+			return err
+		}
+		if err := save(b.commit(c, BuildStats{Added: int64(c.lines)})); err != nil {
+			return err
+		}
+		prog.report(c, b.o.Progress)
+	}
+}
+
+// commit counts s, what writing c did, and returns the Meta with the
+// checkpoint to write along with c.
+func (b *builder) commit(c *chunk, s BuildStats) Meta {
+	b.stats.add(s)
+	b.records += s.Added
+	c.cp.Records, c.cp.Stats = b.records, b.stats
+	m := b.idx.Meta()
+	m.Checkpoint = &c.cp
+	return m
+}
+
+// parallel reads chunks on one goroutine, parses them on Workers
+// goroutines and writes each with write, in order. write is to save the
+// checkpoint of the chunk along with it.
+func (b *builder) parallel(write func(c *chunk) error) error {
+	chunks := make(chan *chunk)
+	parsed := make(chan *chunk)
+	quit := make(chan struct{})
+	readErr := make(chan error, 1)
+	read := make(chan struct{})
+	prog := b.newProgress()
+	go func() {
+		defer close(read)
+		defer close(chunks)
+		for seq := 0; ; seq++ {
+			c, err := b.readChunk(seq)
+			if err != nil {
+				readErr <- err
+				return
+			}
+			if c == nil {
+				return
+			}
+			select {
+			case chunks <- c:
+			case <-quit:
+				return
+			}
+		}
+	}()
+	var wg sync.WaitGroup
+	for i := 0; i < b.o.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for c := range chunks {
+				c.err = b.parse(c)
+				select {
+				case parsed <- c:
+				case <-quit:
+					return
+				}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(parsed)
+	}()
+
+	pending := make(map[int]*chunk)
+	next := 0
+	var err error
+	for c := range parsed {
+		if err != nil {
+			continue
+		}
+		pending[c.seq] = c
+		for c := pending[next]; c != nil && err == nil; c = pending[next] {
+			delete(pending, next)
+			next++
+			if err = c.err; err != nil {
+				break
+			}
+			if err = write(c); err == nil {
+				prog.report(c, b.o.Progress)
+			}
+		}
+		if err != nil {
+			close(quit)
+		}
+	}
+	<-read
+	if err == nil {
+		select {
+		case err = <-readErr:
+		default:
+		}
+	}
+	return err
+}
@@ -0,0 +1,143 @@
+package scoreme
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var errKilled = errors.New("killed")
+
+// killedIndex fails every Insert after the first n, as if the build was
+// killed there.
+type killedIndex struct {
+	Index
+	n int
+}
+
+func (k *killedIndex) Insert(line string) error {
+	if k.n == 0 {
+		return errKilled
+	}
+	k.n--
+	return k.Index.Insert(line)
+}
+
+func (k *killedIndex) setMeta(m Meta) error {
+	return k.Index.(metaWriter).setMeta(m)
+}
+
+// writePasswd writes a passwd file of the hashes of pw0 to pw(n-1), pwN
+// seen N+1 times, and returns its path.
+func writePasswd(t *testing.T, dir string, n int) string {
+	t.Helper()
+	var b strings.Builder
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&b, "%s:%d\n", Hash([]byte(fmt.Sprintf("pw%d", i))), i+1)
+	}
+	path := filepath.Join(dir, "passwd")
+	if err := ioutil.WriteFile(path, []byte(b.String()), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestBuildResumeFirstBatch(t *testing.T) {
+	const lines = 500
+	for _, backend := range []string{"bolt", "fstree"} {
+		dir := t.TempDir()
+		passwd := writePasswd(t, dir, lines)
+		o := Options{Path: filepath.Join(dir, backend), Bucket: "bucket1", Create: true}
+		idx, err := Open(backend, o)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = Build(&killedIndex{Index: idx, n: 50}, passwd, BuildOptions{BatchSize: 200})
+		idx.Close()
+		if err == nil || !strings.Contains(err.Error(), errKilled.Error()) {
+			t.Fatalf("%s: got error %v, want %v", backend, err, errKilled)
+		}
+
+		if idx, err = Open(backend, o); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Build(idx, passwd, BuildOptions{BatchSize: 200}); err == nil {
+			t.Errorf("%s: a new build went over the unfinished one", backend)
+		}
+		if _, err := Build(idx, passwd, BuildOptions{BatchSize: 200, Resume: true}); err != nil {
+			t.Fatalf("%s: resume: %s", backend, err)
+		}
+		if n := idx.Meta().Records; n != lines {
+			t.Errorf("%s: the Meta counts %d records, want %d", backend, n, lines)
+		}
+		var problems []string
+		res, err := Check(idx, func(p Problem) { problems = append(problems, p.String()) })
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Records != lines || len(problems) > 0 {
+			t.Errorf("%s: %d records, want %d, problems %q", backend, res.Records, lines, problems)
+		}
+		idx.Close()
+	}
+}
+
+func TestBuildMerge(t *testing.T) {
+	// The update holds pw400 to pw599: the counts of pw400 to pw449
+	// changed, pw450 to pw499 are as they were and pw500 on are new.
+	counts := make(map[string]int)
+	var b strings.Builder
+	for i := 0; i < 600; i++ {
+		pw := fmt.Sprintf("pw%d", i)
+		counts[pw] = i + 1
+		if 400 <= i && i < 450 {
+			counts[pw] = i + 1000
+		}
+		if i >= 400 {
+			fmt.Fprintf(&b, "%s:%d\n", Hash([]byte(pw)), counts[pw])
+		}
+	}
+	want := newMemIndex(counts)
+	for _, backend := range []string{"bolt", "boltbatch", "fstree"} {
+		dir := t.TempDir()
+		passwd := writePasswd(t, dir, 500)
+		o := Options{Path: filepath.Join(dir, backend), Bucket: "bucket1", Create: true}
+		idx, err := Open(backend, o)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Build(idx, passwd, BuildOptions{BatchSize: 100, TempDir: dir}); err != nil {
+			t.Fatalf("%s: %s", backend, err)
+		}
+		update := filepath.Join(dir, "update")
+		if err := ioutil.WriteFile(update, []byte(b.String()), 0644); err != nil {
+			t.Fatal(err)
+		}
+		stats, err := Build(idx, update, BuildOptions{BatchSize: 64, TempDir: dir, Merge: true})
+		if err != nil {
+			t.Fatalf("%s: merge: %s", backend, err)
+		}
+		if s := (BuildStats{Added: 100, Changed: 50, Unchanged: 50}); stats != s {
+			t.Errorf("%s: got %+v, want %+v", backend, stats, s)
+		}
+		if n := idx.Meta().Records; n != 600 {
+			t.Errorf("%s: the Meta counts %d records, want 600", backend, n)
+		}
+		for h, count := range want {
+			if got, err := idx.Lookup(h); err != nil || got != count {
+				t.Errorf("%s: %s: got %d, %v, want %d", backend, h, got, err, count)
+			}
+		}
+		if _, err := idx.Lookup(Hash([]byte("pw600"))); err != ErrNotFound {
+			t.Errorf("%s: got error %v for a new password, want %v", backend, err, ErrNotFound)
+		}
+		var problems []string
+		if _, err := Check(idx, func(p Problem) { problems = append(problems, p.String()) }); err != nil || len(problems) > 0 {
+			t.Errorf("%s: check: %v %q", backend, err, problems)
+		}
+		idx.Close()
+	}
+}
@@ -0,0 +1,110 @@
+package scoreme
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Problem is a fault in the layout of an index found by Check.
+type Problem struct {
+	// Key is the hex prefix the fault was found under, empty for a fault
+	// of the whole index.
+	Key string
+	// Record counts the records under Key from 1, it is 0 for a fault of
+	// the whole key.
+	Record int
+	Msg    string
+}
+
+func (p Problem) String() string {
+	switch {
+	case p.Key == "":
+		return p.Msg
+	case p.Record == 0:
+		return fmt.Sprintf("key %s: %s", p.Key, p.Msg)
+	}
+	return fmt.Sprintf("key %s record %d: %s", p.Key, p.Record, p.Msg)
+}
+
+// CheckResult counts what Check went through.
+type CheckResult struct {
+	Keys    int64
+	Records int64
+}
+
+// Checker is implemented by indexes that can check their own layout.
+type Checker interface {
+	// Check reads every key and record, calling problem for each fault.
+	// The error is for failing to read the index, not for faults in it.
+	Check(problem func(Problem)) (CheckResult, error)
+}
+
+// Check checks the layout of idx, and that it holds as many records as its
+// Meta says were built.
+func Check(idx Index, problem func(Problem)) (CheckResult, error) {
+	m := idx.Meta()
+	c, ok := idx.(Checker)
+	if !ok {
+		return CheckResult{}, fmt.Errorf("the %s backend can't check its records", m.Backend)
+	}
+	res, err := c.Check(problem)
+	if err != nil {
+		return res, err
+	}
+	switch cp := m.Checkpoint; {
+	case cp != nil:
+		problem(Problem{Msg: fmt.Sprintf("the build of %s stopped at byte %d of %d, finish it with index -resume", cp.Name, cp.Offset, cp.Size)})
+	case m.Built.IsZero() && !m.Stamped:
+		problem(Problem{Msg: "the index has no record of a finished build"})
+	case m.Records != res.Records:
+		problem(Problem{Msg: fmt.Sprintf("the build put %d records in the index, found %d", m.Records, res.Records)})
+	}
+	return res, nil
+}
+
+// keyChecker checks the records under one key as they are read.
+type keyChecker struct {
+	key     string
+	problem func(Problem)
+	// sorted is set when the records must be in increasing order,
+	// otherwise they are only checked for duplicates.
+	sorted bool
+	n      int
+	prev   string
+	seen   map[string]bool
+}
+
+func newKeyChecker(key string, sorted bool, problem func(Problem)) *keyChecker {
+	k := &keyChecker{key: key, sorted: sorted, problem: problem}
+	if !sorted {
+		k.seen = make(map[string]bool)
+	}
+	return k
+}
+
+// fault reports a fault of the current record.
+func (k *keyChecker) fault(format string, a ...interface{}) {
+	k.problem(Problem{Key: k.key, Record: k.n, Msg: fmt.Sprintf(format, a...)})
+}
+
+// record checks the next record, with the uppercase hex hash h and the
+// unparsed count.
+func (k *keyChecker) record(h string, count []byte) {
+	k.n++
+	if !strings.HasPrefix(h, k.key) {
+		k.fault("hash %s does not start with the key", h)
+	}
+	switch {
+	case k.sorted && k.n > 1 && h <= k.prev:
+		k.fault("hash %s is not after %s", h, k.prev)
+	case !k.sorted && k.seen[h]:
+		k.fault("hash %s is repeated", h)
+	}
+	if k.seen != nil {
+		k.seen[h] = true
+	}
+	k.prev = h
+	if _, err := parseCount(count); err != nil {
+		k.fault("count %q: %s", strings.TrimSpace(string(count)), err)
+	}
+}
@@ -0,0 +1,428 @@
+package scoreme
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/boltdb/bolt"
+)
+
+var (
+	teamsBucket       = []byte("teams")
+	hitsBucket        = []byte("hits")
+	submissionsBucket = []byte("submissions")
+)
+
+var (
+	// ErrNoTeam is returned for a team that is not registered.
+	ErrNoTeam = errors.New("no such team")
+	// ErrNoSubmission is returned for a submission that does not exist.
+	ErrNoSubmission = errors.New("no such submission")
+)
+
+// Team is one competitor and its score so far.
+type Team struct {
+	// Rank is 1 for the best team. Teams with the same score and bonus
+	// share a rank.
+	Rank       int     `json:"rank"`
+	Name       string  `json:"name"`
+	Score      int     `json:"score"`
+	Bonus      float32 `json:"bonus"`
+	Hits       int     `json:"hits"`
+	Misses     int     `json:"misses"`
+	Duplicates int     `json:"duplicates"`
+}
+
+func newTeam(name string, r Result) Team {
+	return Team{
+		Name:       name,
+		Score:      r.Score,
+		Bonus:      r.Bonus,
+		Hits:       r.Hits,
+		Misses:     r.Misses,
+		Duplicates: r.Duplicates,
+	}
+}
+
+// teamRecord is what is stored for a team.
+type teamRecord struct {
+	Name      string `json:"name"`
+	TokenHash string `json:"token_hash"`
+	Result    Result `json:"result"`
+}
+
+// team is a registered team. TokenHash is only changed with both mu and
+// c.mu held, taken in that order, Result only with mu held. c.mu is never
+// held while waiting for mu.
+type team struct {
+	teamRecord
+	scorer *Scorer
+	// mu serialises submissions of the team so they are saved in order.
+	mu sync.Mutex
+}
+
+// Competition keeps a separate Scorer for every team, so hits and
+// duplicates are judged per team, and saves the teams in a bolt database
+// so a restart does not lose the scores.
+type Competition struct {
+	Index Index
+	Rules Rules
+	// Workers is passed on to the Scorer of every team.
+	Workers int
+
+	db       *bolt.DB
+	mu       sync.Mutex
+	teams    map[string]*team
+	watchers map[chan struct{}]bool
+}
+
+// OpenCompetition opens or creates the competition state at path. The
+// teams look up their passwords with workers goroutines, see Scorer.
+func OpenCompetition(path string, idx Index, rules Rules, workers int) (*Competition, error) {
+	db, err := bolt.Open(path, 0600, nil)
+	if err != nil {
+		return nil, err
+	}
+	c := &Competition{
+		Index:    idx,
+		Rules:    rules,
+		Workers:  workers,
+		db:       db,
+		teams:    make(map[string]*team),
+		watchers: make(map[chan struct{}]bool),
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		tb, err := tx.CreateBucketIfNotExists(teamsBucket)
+		if err != nil {
+			return err
+		}
+		hb, err := tx.CreateBucketIfNotExists(hitsBucket)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.CreateBucketIfNotExists(submissionsBucket); err != nil {
+			return err
+		}
+		return tb.ForEach(func(k, v []byte) error {
+			t := &team{}
+			if err := json.Unmarshal(v, &t.teamRecord); err != nil {
+				return fmt.Errorf("team %s: %s", k, err)
+			}
+			var hits []string
+			if b := hb.Bucket(k); b != nil {
+				b.ForEach(func(h, _ []byte) error {
+					hits = append(hits, string(h))
+					return nil
+				})
+			}
+			t.scorer = c.newScorer()
+			t.scorer.Restore(t.Result, hits)
+			c.teams[t.Name] = t
+			return nil
+		})
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *Competition) newScorer() *Scorer {
+	return &Scorer{Index: c.Index, Rules: c.Rules, Workers: c.Workers}
+}
+
+func hashToken(token string) string {
+	h := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(h[:])
+}
+
+// NewToken returns a random token for a team.
+func NewToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// AddTeam registers a team, or changes the token of an existing one
+// keeping its score.
+func (c *Competition) AddTeam(name, token string) error {
+	if name == "" || token == "" {
+		return errors.New("a team needs a name and a token")
+	}
+	c.mu.Lock()
+	t, ok := c.teams[name]
+	if !ok {
+		// No one else sees a new team before it is saved.
+		defer c.mu.Unlock()
+		t = &team{teamRecord: teamRecord{Name: name, TokenHash: hashToken(token)}, scorer: c.newScorer()}
+		t.Result = t.scorer.Result()
+		if err := c.db.Update(func(tx *bolt.Tx) error { return putTeam(tx, t.teamRecord) }); err != nil {
+			return err
+		}
+		c.teams[name] = t
+		c.notify()
+		return nil
+	}
+	// A submission of the team holds t.mu until it is saved, c.mu is let
+	// go first so the other teams are not held up meanwhile.
+	c.mu.Unlock()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	rec := t.teamRecord
+	rec.TokenHash = hashToken(token)
+	if err := c.db.Update(func(tx *bolt.Tx) error { return putTeam(tx, rec) }); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	t.TokenHash = rec.TokenHash
+	c.notify()
+	c.mu.Unlock()
+	return nil
+}
+
+// Watch returns a channel that receives a value whenever a score or the
+// list of teams changes. Changes made while the receiver is busy are
+// collapsed into one. Call stop when done watching.
+func (c *Competition) Watch() (ch <-chan struct{}, stop func()) {
+	w := make(chan struct{}, 1)
+	c.mu.Lock()
+	c.watchers[w] = true
+	c.mu.Unlock()
+	return w, func() {
+		c.mu.Lock()
+		delete(c.watchers, w)
+		c.mu.Unlock()
+	}
+}
+
+// notify wakes the watchers, c.mu must be held.
+func (c *Competition) notify() {
+	for w := range c.watchers {
+		select {
+		case w <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// teamsFile is the layout of a teams config file.
+type teamsFile struct {
+	Team []struct {
+		Name  string `toml:"name"`
+		Token string `toml:"token"`
+	} `toml:"team"`
+}
+
+// LoadTeams registers the teams listed in a TOML file of [[team]] tables
+// with a name and a token.
+func (c *Competition) LoadTeams(path string) error {
+	var f teamsFile
+	if _, err := toml.DecodeFile(path, &f); err != nil {
+		return err
+	}
+	for _, t := range f.Team {
+		if err := c.AddTeam(t.Name, t.Token); err != nil {
+			return fmt.Errorf("%s: %s", path, err)
+		}
+	}
+	return nil
+}
+
+func putTeam(tx *bolt.Tx, rec teamRecord) error {
+	v, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(teamsBucket).Put([]byte(rec.Name), v)
+}
+
+// Auth reports whether token belongs to the named team.
+func (c *Competition) Auth(name, token string) bool {
+	var want string
+	c.mu.Lock()
+	if t, ok := c.teams[name]; ok {
+		want = t.TokenHash
+	}
+	c.mu.Unlock()
+	if want == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(want), []byte(hashToken(token))) == 1
+}
+
+// Teams returns every team ranked, best score first.
+func (c *Competition) Teams() []Team {
+	c.mu.Lock()
+	var res []Team
+	for _, t := range c.teams {
+		res = append(res, newTeam(t.Name, t.scorer.Result()))
+	}
+	c.mu.Unlock()
+	rank(res)
+	return res
+}
+
+// rank sorts the teams best score first and sets their Rank.
+func rank(res []Team) {
+	sort.Slice(res, func(i, j int) bool {
+		a, b := res[i], res[j]
+		if a.Score != b.Score {
+			return a.Score > b.Score
+		}
+		if a.Bonus != b.Bonus {
+			return a.Bonus > b.Bonus
+		}
+		return a.Name < b.Name
+	})
+	for i := range res {
+		res[i].Rank = i + 1
+		if i > 0 && res[i].Score == res[i-1].Score && res[i].Bonus == res[i-1].Bonus {
+			res[i].Rank = res[i-1].Rank
+		}
+	}
+}
+
+// Submission is one batch of candidate passwords scored for a team.
+type Submission struct {
+	ID   uint64    `json:"id"`
+	Team string    `json:"team"`
+	Time time.Time `json:"time"`
+	// Score, Bonus and the counts are for this submission alone.
+	Score      int     `json:"score"`
+	Bonus      float32 `json:"bonus"`
+	Hits       int     `json:"hits"`
+	Misses     int     `json:"misses"`
+	Duplicates int     `json:"duplicates"`
+	Lines      []Line  `json:"lines"`
+	// Total is the score of the team after this submission.
+	Total Result `json:"total"`
+}
+
+func (s *Submission) add(l Line) {
+	s.Score += l.Points
+	s.Bonus += l.Bonus
+	switch l.Verdict {
+	case Hit:
+		s.Hits++
+	case Miss:
+		s.Misses++
+	case Duplicate:
+		s.Duplicates++
+	}
+	s.Lines = append(s.Lines, l)
+}
+
+// Submit scores the candidate passwords of sc for the named team, passing
+// every line to report if it is not nil. The lines are in the input format
+// input, see Scorer.Input. The submission is saved along with the team's
+// new score, also when ctx is done before the end of sc.
+func (c *Competition) Submit(ctx context.Context, name, input string, sc *bufio.Scanner, report func(Line) error) (*Submission, error) {
+	c.mu.Lock()
+	t, ok := c.teams[name]
+	c.mu.Unlock()
+	if !ok {
+		return nil, ErrNoTeam
+	}
+	t.mu.Lock()
+	sub := &Submission{Team: name, Time: time.Now().UTC()}
+	t.scorer.Input = input
+	err := t.scorer.Scan(ctx, sc, func(l Line) error {
+		sub.add(l)
+		if report != nil {
+			return report(l)
+		}
+		return nil
+	})
+	rec := t.teamRecord
+	rec.Result = t.scorer.Result()
+	sub.Total = rec.Result
+	// Whatever scored before an error still counts, so save it anyway.
+	serr := c.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.Bucket(hitsBucket).CreateBucketIfNotExists([]byte(name))
+		if err != nil {
+			return err
+		}
+		for _, l := range sub.Lines {
+			if l.Verdict != Hit {
+				continue
+			}
+			if err := b.Put([]byte(l.Hash), []byte{}); err != nil {
+				return err
+			}
+		}
+		sb := tx.Bucket(submissionsBucket)
+		if sub.ID, err = sb.NextSequence(); err != nil {
+			return err
+		}
+		v, err := json.Marshal(sub)
+		if err != nil {
+			return err
+		}
+		if err := sb.Put(submissionKey(sub.ID), v); err != nil {
+			return err
+		}
+		return putTeam(tx, rec)
+	})
+	t.Result = rec.Result
+	t.mu.Unlock()
+	c.mu.Lock()
+	c.notify()
+	c.mu.Unlock()
+	if err == nil {
+		err = serr
+	}
+	return sub, err
+}
+
+func submissionKey(id uint64) []byte {
+	k := make([]byte, 8)
+	binary.BigEndian.PutUint64(k, id)
+	return k
+}
+
+// Submission returns a saved submission.
+func (c *Competition) Submission(id uint64) (*Submission, error) {
+	var sub *Submission
+	err := c.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(submissionsBucket).Get(submissionKey(id))
+		if v == nil {
+			return nil
+		}
+		sub = &Submission{}
+		return json.Unmarshal(v, sub)
+	})
+	if err == nil && sub == nil {
+		err = ErrNoSubmission
+	}
+	return sub, err
+}
+
+// Team returns the ranked standing of the named team.
+func (c *Competition) Team(name string) (Team, error) {
+	for _, t := range c.Teams() {
+		if t.Name == name {
+			return t, nil
+		}
+	}
+	return Team{}, ErrNoTeam
+}
+
+// Close closes the state database.
+func (c *Competition) Close() error {
+	return c.db.Close()
+}
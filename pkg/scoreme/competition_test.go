@@ -0,0 +1,95 @@
+package scoreme
+
+import (
+	"bufio"
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAddTeamDuringSubmission(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "competition.db")
+	c, err := OpenCompetition(path, testIndex(), DefaultRules, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a", "b"} {
+		if err := c.AddTeam(name, name+"-token"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The submission of a stops at its first line until released.
+	scoring, release := make(chan struct{}), make(chan struct{})
+	submitted := make(chan error)
+	go func() {
+		sc := bufio.NewScanner(strings.NewReader("pw1\npw2\n"))
+		_, err := c.Submit(context.Background(), "a", "", sc, func(l Line) error {
+			if l.N == 1 {
+				close(scoring)
+				<-release
+			}
+			return nil
+		})
+		submitted <- err
+	}()
+	<-scoring
+	changed := make(chan error)
+	go func() {
+		changed <- c.AddTeam("a", "new-token")
+	}()
+	// Give the token change time to start waiting.
+	time.Sleep(100 * time.Millisecond)
+
+	// The token change of a waits for its submission, the others don't.
+	done := make(chan bool)
+	go func() {
+		c.Teams()
+		done <- c.Auth("b", "b-token") && c.AddTeam("b", "b-token") == nil && c.AddTeam("c", "c-token") == nil
+	}()
+	select {
+	case ok := <-done:
+		if !ok {
+			t.Error("the other teams were not registered")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the other teams are held up by the submission of a")
+	}
+	select {
+	case err := <-changed:
+		t.Fatalf("the token changed during the submission, error %v", err)
+	default:
+	}
+
+	close(release)
+	if err := <-submitted; err != nil {
+		t.Fatal(err)
+	}
+	if err := <-changed; err != nil {
+		t.Fatal(err)
+	}
+	if c.Auth("a", "a-token") || !c.Auth("a", "new-token") {
+		t.Error("the token of a did not change")
+	}
+	if tm, err := c.Team("a"); err != nil || tm.Hits != 2 {
+		t.Errorf("got team %+v, %v, want 2 hits", tm, err)
+	}
+
+	// Both the score and the new token are saved.
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+	c, err = OpenCompetition(path, testIndex(), DefaultRules, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if !c.Auth("a", "new-token") {
+		t.Error("the new token of a was not saved")
+	}
+	if tm, err := c.Team("a"); err != nil || tm.Hits != 2 {
+		t.Errorf("got saved team %+v, %v, want 2 hits", tm, err)
+	}
+}
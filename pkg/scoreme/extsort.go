@@ -0,0 +1,216 @@
+package scoreme
+
+import (
+	"bufio"
+	"container/heap"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// sortedInput is implemented by indexes that need the passwd file sorted
+// by hash.
+type sortedInput interface {
+	sortedInput()
+}
+
+// defaultSortMemory is the default of BuildOptions.SortMemory.
+const defaultSortMemory = 256 << 20
+
+// hashKey returns the uppercase hash of a passwd line, which lines are
+// sorted by.
+func hashKey(l string) string {
+	if i := strings.Index(l, ":"); i != -1 {
+		l = l[:i]
+	}
+	return strings.ToUpper(l)
+}
+
+// checkSorted reads a passwd file to the end, returning whether its lines
+// are sorted by hash and the SHA-256 of the file.
+func checkSorted(r io.Reader) (bool, string, error) {
+	h := sha256.New()
+	p := bufio.NewScanner(io.TeeReader(r, h))
+	sorted := true
+	var prev string
+	for p.Scan() {
+		l := strings.TrimRight(p.Text(), "\r\n")
+		if l == "" || !sorted {
+			continue
+		}
+		k := hashKey(l)
+		if k < prev {
+			sorted = false
+		}
+		prev = k
+	}
+	if err := p.Err(); err != nil {
+		return false, "", err
+	}
+	return sorted, hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// byHash sorts passwd lines by hash, then by the whole line so the order
+// does not depend on how the file was cut into runs.
+type byHash []string
+
+func (s byHash) Len() int      { return len(s) }
+func (s byHash) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s byHash) Less(i, j int) bool {
+	return lineLess(s[i], s[j])
+}
+
+func lineLess(a, b string) bool {
+	ka, kb := hashKey(a), hashKey(b)
+	if ka != kb {
+		return ka < kb
+	}
+	return a < b
+}
+
+// sortFile sorts the lines of the passwd file at path by hash, leaving out
+// blank lines, with roughly mem bytes of lines in memory at a time. Sorted
+// runs are written to a new directory in dir and merged into the file it
+// returns. The caller removes the directory, which is returned even along
+// with an error if it was made.
+func sortFile(path, dir string, mem int64) (sorted, tmp string, err error) {
+	if mem <= 0 {
+		mem = defaultSortMemory
+	}
+	fh, err := os.Open(path)
+	if err != nil {
+		return "", "", err
+	}
+	defer fh.Close()
+	if tmp, err = ioutil.TempDir(dir, "scoreme-sort"); err != nil {
+		return "", "", err
+	}
+	var runs []string
+	var lines []string
+	var size int64
+	writeRun := func() error {
+		sort.Sort(byHash(lines))
+		name := filepath.Join(tmp, fmt.Sprintf("run%d", len(runs)))
+		if err := writeLines(name, lines); err != nil {
+			return err
+		}
+		runs = append(runs, name)
+		lines, size = lines[:0], 0
+		return nil
+	}
+	p := bufio.NewScanner(fh)
+	for p.Scan() {
+		l := strings.TrimRight(p.Text(), "\r\n")
+		if l == "" {
+			continue
+		}
+		lines = append(lines, l)
+		// Count the string header along with the line.
+		size += int64(len(l)) + 16
+		if size >= mem {
+			if err := writeRun(); err != nil {
+				return "", tmp, err
+			}
+		}
+	}
+	if err := p.Err(); err != nil {
+		return "", tmp, err
+	}
+	if len(lines) > 0 || len(runs) == 0 {
+		if err := writeRun(); err != nil {
+			return "", tmp, err
+		}
+	}
+	lines = nil
+	if len(runs) == 1 {
+		return runs[0], tmp, nil
+	}
+	sorted = filepath.Join(tmp, "sorted")
+	return sorted, tmp, mergeRuns(sorted, runs)
+}
+
+func writeLines(name string, lines []string) error {
+	fh, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(fh)
+	for _, l := range lines {
+		w.WriteString(l)
+		w.WriteByte('\n')
+	}
+	err = w.Flush()
+	if cerr := fh.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// run is a sorted run being merged, at its line l.
+type run struct {
+	p *bufio.Scanner
+	l string
+}
+
+// runHeap is a heap of runs by their current line.
+type runHeap []*run
+
+func (h runHeap) Len() int            { return len(h) }
+func (h runHeap) Less(i, j int) bool  { return lineLess(h[i].l, h[j].l) }
+func (h runHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *runHeap) Push(x interface{}) { *h = append(*h, x.(*run)) }
+func (h *runHeap) Pop() interface{} {
+	old := *h
+	r := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return r
+}
+
+// mergeRuns merges the sorted run files into the file name.
+func mergeRuns(name string, runs []string) error {
+	var h runHeap
+	for _, r := range runs {
+		fh, err := os.Open(r)
+		if err != nil {
+			return err
+		}
+		defer fh.Close()
+		p := bufio.NewScanner(fh)
+		if p.Scan() {
+			h = append(h, &run{p: p, l: p.Text()})
+		} else if err := p.Err(); err != nil {
+			return err
+		}
+	}
+	heap.Init(&h)
+	out, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	w := bufio.NewWriter(out)
+	for h.Len() > 0 {
+		r := h[0]
+		w.WriteString(r.l)
+		w.WriteByte('\n')
+		if r.p.Scan() {
+			r.l = r.p.Text()
+			heap.Fix(&h, 0)
+			continue
+		}
+		if err := r.p.Err(); err != nil {
+			return err
+		}
+		heap.Pop(&h)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,94 @@
+package scoreme
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestSortFile(t *testing.T) {
+	// many has 500 lines over few hashes, so runs share keys.
+	var many []string
+	for i := 0; i < 500; i++ {
+		many = append(many, fmt.Sprintf("%X:%d", (i*7919)%61, i%3))
+	}
+	manyWant := append([]string(nil), many...)
+	sort.Sort(byHash(manyWant))
+	tests := []struct {
+		name  string
+		input string
+		mem   int64
+		want  []string
+		// runs is the least number of runs the lines are sorted in.
+		runs int
+	}{
+		{"empty", "", 100, nil, 1},
+		{"blank lines", "\n\r\n\n", 100, nil, 1},
+		{"one run", "BB:1\nAA:2\ncc:3\n", 1 << 20, []string{"AA:2", "BB:1", "cc:3"}, 1},
+		{"a run a line", "BB:1\nAA:2\ncc:3\n", 1, []string{"AA:2", "BB:1", "cc:3"}, 3},
+		{"duplicate keys", "AA:3\nBB:1\naa:1\nAA:2\nAA:3\n", 40, []string{"AA:2", "AA:3", "AA:3", "aa:1", "BB:1"}, 3},
+		{"no counts", "BB\r\nAA\n\nAA:1\n", 20, []string{"AA", "AA:1", "BB"}, 2},
+		{"many runs", strings.Join(many, "\n") + "\n", 200, manyWant, 40},
+	}
+	for _, tc := range tests {
+		dir := t.TempDir()
+		path := filepath.Join(dir, "passwd")
+		if err := ioutil.WriteFile(path, []byte(tc.input), 0644); err != nil {
+			t.Fatal(err)
+		}
+		sorted, tmp, err := sortFile(path, dir, tc.mem)
+		if err != nil {
+			t.Fatalf("%s: %s", tc.name, err)
+		}
+		b, err := ioutil.ReadFile(sorted)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		if len(b) > 0 {
+			got = strings.Split(strings.TrimSuffix(string(b), "\n"), "\n")
+		}
+		if len(got) != len(tc.want) {
+			t.Errorf("%s: got %d lines, want %d", tc.name, len(got), len(tc.want))
+		} else if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: got %q, want %q", tc.name, got, tc.want)
+		}
+		files, err := filepath.Glob(filepath.Join(tmp, "run*"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(files) < tc.runs {
+			t.Errorf("%s: sorted in %d runs, want at least %d", tc.name, len(files), tc.runs)
+		}
+		os.RemoveAll(tmp)
+	}
+}
+
+func TestMergeRuns(t *testing.T) {
+	dir := t.TempDir()
+	runs := []string{"AA:1\nCC:1\n", "", "aa:0\nBB:1\nDD:1\n", "CC:1\n"}
+	var names []string
+	for i, r := range runs {
+		name := filepath.Join(dir, fmt.Sprintf("run%d", i))
+		if err := ioutil.WriteFile(name, []byte(r), 0644); err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, name)
+	}
+	out := filepath.Join(dir, "sorted")
+	if err := mergeRuns(out, names); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "AA:1\naa:0\nBB:1\nCC:1\nCC:1\nDD:1\n"; string(b) != want {
+		t.Errorf("got %q, want %q", b, want)
+	}
+}
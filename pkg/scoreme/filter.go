@@ -0,0 +1,260 @@
+package scoreme
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"time"
+)
+
+// filterMagic starts every filter file.
+var filterMagic = []byte("SCOREME\x00bloom\x00\x01")
+
+// FilterPath returns where the filter of the index at path is kept.
+func FilterPath(path string) string {
+	return path + ".filter"
+}
+
+// FilterMeta describes a filter and the build of the index it was made
+// from.
+type FilterMeta struct {
+	// Records and Built are copied from the Meta of the index, a filter
+	// that disagrees with them is stale.
+	Records int64     `json:"records"`
+	Built   time.Time `json:"built"`
+	// Rate is the false positive rate the filter was sized for.
+	Rate   float64 `json:"rate"`
+	Bits   uint64  `json:"bits"`
+	Hashes uint32  `json:"hashes"`
+}
+
+// Filter is a bloom filter of the hashes in an index. A hash it does not
+// hold is certainly not in the index, so most misses are scored without a
+// lookup. The bits are set from the first 16 bytes of the hash, which are
+// random enough to need no hashing of their own.
+type Filter struct {
+	meta FilterMeta
+	// data is the mapped file of an opened filter, bits is in it.
+	data []byte
+	bits []byte
+}
+
+// NewFilter returns an empty filter for n hashes with a false positive
+// rate of about rate.
+func NewFilter(n int64, rate float64) (*Filter, error) {
+	if rate <= 0 || rate >= 1 {
+		return nil, fmt.Errorf("the false positive rate of a filter must be between 0 and 1, not %g", rate)
+	}
+	if n < 1 {
+		n = 1
+	}
+	bits := uint64(math.Ceil(-float64(n) * math.Log(rate) / (math.Ln2 * math.Ln2)))
+	bits = (bits + 63) &^ 63
+	k := uint32(math.Round(float64(bits) / float64(n) * math.Ln2))
+	if k < 1 {
+		k = 1
+	}
+	return &Filter{
+		meta: FilterMeta{Rate: rate, Bits: bits, Hashes: k},
+		bits: make([]byte, bits/8),
+	}, nil
+}
+
+// BuildFilter makes a filter of every hash in idx.
+func BuildFilter(idx Index, rate float64) (*Filter, error) {
+	m := idx.Meta()
+	w, ok := idx.(Walker)
+	if !ok {
+		return nil, fmt.Errorf("the %s backend can't list its records for a filter", m.Backend)
+	}
+	if m.Checkpoint != nil {
+		return nil, errors.New("the index has no finished build to make a filter of")
+	}
+	f, err := NewFilter(m.Records, rate)
+	if err != nil {
+		return nil, err
+	}
+	f.meta.Records, f.meta.Built = m.Records, m.Built
+	raw := make([]byte, 16)
+	var n int64
+	err = w.Walk(func(h string, count int) error {
+		n++
+		if len(h) < 32 {
+			return fmt.Errorf("hash %s is too short for a filter", h)
+		}
+		if _, err := hex.Decode(raw, []byte(h[:32])); err != nil {
+			return err
+		}
+		f.add(raw)
+		return nil
+	})
+	if err == nil && n != m.Records {
+		err = fmt.Errorf("the build put %d records in the index, found %d", m.Records, n)
+	}
+	return f, err
+}
+
+// positions returns the two halves of raw that the bits of the hash are
+// picked from.
+func positions(raw []byte) (uint64, uint64) {
+	return binary.BigEndian.Uint64(raw), binary.BigEndian.Uint64(raw[8:]) | 1
+}
+
+func (f *Filter) add(raw []byte) {
+	a, b := positions(raw)
+	for i := uint32(0); i < f.meta.Hashes; i++ {
+		bit := a % f.meta.Bits
+		f.bits[bit>>3] |= 1 << (bit & 7)
+		a += b
+	}
+}
+
+// Has reports whether the uppercase hex hash h may be in the index. It is
+// true for anything it can't decode, leaving that to the index.
+func (f *Filter) Has(h string) bool {
+	var raw [16]byte
+	if len(h) < 32 {
+		return true
+	}
+	if _, err := hex.Decode(raw[:], []byte(h[:32])); err != nil {
+		return true
+	}
+	a, b := positions(raw[:])
+	for i := uint32(0); i < f.meta.Hashes; i++ {
+		bit := a % f.meta.Bits
+		if f.bits[bit>>3]&(1<<(bit&7)) == 0 {
+			return false
+		}
+		a += b
+	}
+	return true
+}
+
+// Meta describes the filter.
+func (f *Filter) Meta() FilterMeta {
+	return f.meta
+}
+
+// Fits returns an error if the filter was not made from the build of the
+// index described by m.
+func (f *Filter) Fits(m Meta) error {
+	if f.meta.Records != m.Records || !f.meta.Built.Equal(m.Built) {
+		return fmt.Errorf("the filter is of the build of %s, the index was built at %s, rebuild it with index -filter",
+			f.meta.Built.Format(time.RFC3339), m.Built.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// Write saves the filter to path, replacing any filter there.
+func (f *Filter) Write(path string) error {
+	meta, err := json.Marshal(f.meta)
+	if err != nil {
+		return err
+	}
+	fh, err := os.Create(path + ".new")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(fh.Name())
+	defer fh.Close()
+	w := bufio.NewWriter(fh)
+	w.Write(filterMagic)
+	binary.Write(w, binary.BigEndian, uint32(len(meta)))
+	w.Write(meta)
+	hlen := len(filterMagic) + 4 + len(meta)
+	w.Write(make([]byte, align8(hlen)-hlen))
+	w.Write(f.bits)
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if err := fh.Close(); err != nil {
+		return err
+	}
+	return os.Rename(fh.Name(), path)
+}
+
+// OpenFilter maps the filter file at path. Check it Fits the index before
+// using it.
+func OpenFilter(path string) (*Filter, error) {
+	fh, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+	f := &Filter{}
+	if err := f.open(fh); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	return f, nil
+}
+
+func (f *Filter) open(fh *os.File) error {
+	fi, err := fh.Stat()
+	if err != nil {
+		return err
+	}
+	hlen := len(filterMagic) + 4
+	if fi.Size() < int64(hlen) {
+		return errors.New("not a filter")
+	}
+	if int64(int(fi.Size())) != fi.Size() {
+		return errors.New("the file is too big to map")
+	}
+	if f.data, err = mmap(fh, int(fi.Size())); err != nil {
+		return err
+	}
+	if !bytes.Equal(f.data[:len(filterMagic)], filterMagic) {
+		return errors.New("not a filter")
+	}
+	mlen := int(binary.BigEndian.Uint32(f.data[len(filterMagic):hlen]))
+	if len(f.data) < hlen+mlen {
+		return errors.New("the file is cut short")
+	}
+	if err := json.Unmarshal(f.data[hlen:hlen+mlen], &f.meta); err != nil {
+		return fmt.Errorf("meta: %s", err)
+	}
+	f.bits = f.data[align8(hlen+mlen):]
+	if f.meta.Bits == 0 || f.meta.Hashes == 0 || uint64(len(f.bits))*8 != f.meta.Bits {
+		return fmt.Errorf("the filter has %d bytes of bits, its meta says %d bits", len(f.bits), f.meta.Bits)
+	}
+	return nil
+}
+
+// Close unmaps an opened filter.
+func (f *Filter) Close() error {
+	return munmap(f.data)
+}
+
+// FilteredIndex puts a Filter in front of an Index, so lookups of hashes
+// the filter rules out never reach the index.
+type FilteredIndex struct {
+	Index
+	Filter *Filter
+}
+
+// Lookup returns ErrNotFound for a hash the filter rules out, and looks up
+// the rest.
+func (i *FilteredIndex) Lookup(h string) (int, error) {
+	if !i.Filter.Has(h) {
+		return 0, ErrNotFound
+	}
+	return i.Index.Lookup(h)
+}
+
+// mayHave rules out a hash without a lookup, see Scorer.
+func (i *FilteredIndex) mayHave(h string) bool {
+	return i.Filter.Has(h)
+}
+
+// Close closes the filter and the index.
+func (i *FilteredIndex) Close() error {
+	i.Filter.Close()
+	return i.Index.Close()
+}
@@ -0,0 +1,49 @@
+package scoreme
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestFilterStampedAndMigrated(t *testing.T) {
+	dir := t.TempDir()
+	mem := testIndex()
+	v1 := filepath.Join(dir, "v1.db")
+	writeV1(t, v1, mem)
+	o := Options{Path: v1, Bucket: "bucket1", PrefixLen: 4}
+	if _, err := Stamp("boltbatch", o); err != nil {
+		t.Fatal(err)
+	}
+	v2 := filepath.Join(dir, "v2.db")
+	if _, err := Migrate(o, v2); err != nil {
+		t.Fatal(err)
+	}
+
+	var filters []*Filter
+	for _, path := range []string{v1, v2} {
+		idx, err := OpenBoltBatch(Options{Path: path, Bucket: "bucket1"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer idx.Close()
+		f, err := BuildFilter(idx, 0.01)
+		if err != nil {
+			t.Fatalf("%s: %s", path, err)
+		}
+		if err := f.Fits(idx.Meta()); err != nil {
+			t.Errorf("%s: %s", path, err)
+		}
+		for h := range mem {
+			if !f.Has(h) {
+				t.Fatalf("%s: the filter lacks %s", path, h)
+			}
+		}
+		filters = append(filters, f)
+		if len(filters) == 2 {
+			// Both hold the same records, the builds tell them apart.
+			if err := filters[0].Fits(idx.Meta()); err == nil {
+				t.Error("the filter of the stamped index fits the migrated one")
+			}
+		}
+	}
+}
@@ -0,0 +1,347 @@
+package scoreme
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strings"
+)
+
+// flatMagic starts every flat file.
+var flatMagic = []byte("SCOREME\x00flat\x00\x00\x01")
+
+// maxFlatPrefixLen keeps the offset table of a flat file under 128MB.
+const maxFlatPrefixLen = 6
+
+// Flat is a read only Index in a single file, made to be copied to the
+// machines that score. The file holds the Meta, an offset table and the
+// records sorted by hash, each the raw hash and the count as a big endian
+// uint32. Entry p of the table is the number of the first record whose
+// hash starts with prefix p, so a lookup is one binary search between two
+// entries. The file is mapped into memory rather than read.
+//
+// A new Flat is built by inserting sorted lines, which are written to a
+// temporary file and put together with the table on Close. It can't be
+// resumed or added to once it is written.
+type Flat struct {
+	opts Options
+	meta Meta
+
+	// data is the mapped file, cut into table and records.
+	data           []byte
+	table, records []byte
+	reclen         int
+
+	// While building, records are written to tmp through w.
+	tmp    *os.File
+	w      *bufio.Writer
+	counts []uint64
+	last   []byte
+}
+
+// OpenFlat opens the flat index at o.Path, or with o.Create starts
+// building one if there is no file there.
+func OpenFlat(o Options) (*Flat, error) {
+	i := &Flat{opts: o}
+	fh, err := os.Open(o.Path)
+	if os.IsNotExist(err) && !o.Create {
+		return nil, fmt.Errorf("%s: no such index", o.Path)
+	}
+	if os.IsNotExist(err) {
+		if i.meta, err = resolveMeta("flat", nil, o); err != nil {
+			return nil, fmt.Errorf("%s: %s", o.Path, err)
+		}
+		i.reclen = i.meta.Algorithm.Size() + 4
+		if i.tmp, err = os.Create(o.Path + ".tmp"); err != nil {
+			return nil, err
+		}
+		i.w = bufio.NewWriter(i.tmp)
+		i.counts = make([]uint64, 1<<(4*i.meta.PrefixLen))
+		return i, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+	if err := i.open(fh); err != nil {
+		i.Close()
+		return nil, fmt.Errorf("%s: %s", o.Path, err)
+	}
+	return i, nil
+}
+
+// open maps a finished flat file and checks its Meta and size.
+func (i *Flat) open(fh *os.File) error {
+	fi, err := fh.Stat()
+	if err != nil {
+		return err
+	}
+	hlen := len(flatMagic) + 4
+	if fi.Size() < int64(hlen) {
+		return errors.New("not a flat index")
+	}
+	if int64(int(fi.Size())) != fi.Size() {
+		return errors.New("the file is too big to map")
+	}
+	if i.data, err = mmap(fh, int(fi.Size())); err != nil {
+		return err
+	}
+	if !bytes.Equal(i.data[:len(flatMagic)], flatMagic) {
+		return errors.New("not a flat index")
+	}
+	mlen := int(binary.BigEndian.Uint32(i.data[len(flatMagic):hlen]))
+	if len(i.data) < hlen+mlen {
+		return errors.New("the file is cut short")
+	}
+	var stored Meta
+	if err := json.Unmarshal(i.data[hlen:hlen+mlen], &stored); err != nil {
+		return fmt.Errorf("meta: %s", err)
+	}
+	if i.meta, err = resolveMeta("flat", &stored, i.opts); err != nil {
+		return err
+	}
+	i.reclen = i.meta.Algorithm.Size() + 4
+	start := align8(hlen + mlen)
+	end := start + 8*(1<<(4*i.meta.PrefixLen)+1)
+	if len(i.data) < end {
+		return errors.New("the file is cut short")
+	}
+	i.table, i.records = i.data[start:end], i.data[end:]
+	if n := i.entry(len(i.table)/8 - 1); uint64(len(i.records)) != n*uint64(i.reclen) {
+		return fmt.Errorf("the table counts %d records, the file holds %d bytes of them", n, len(i.records))
+	}
+	return nil
+}
+
+func align8(n int) int {
+	return (n + 7) &^ 7
+}
+
+// entry returns entry p of the offset table.
+func (i *Flat) entry(p int) uint64 {
+	return binary.BigEndian.Uint64(i.table[8*p:])
+}
+
+// prefix returns the offset table entry for the raw hash h.
+func (i *Flat) prefix(h []byte) int {
+	return int(binary.BigEndian.Uint32(h) >> (32 - 4*i.meta.PrefixLen))
+}
+
+// Meta describes how the index was built.
+func (i *Flat) Meta() Meta {
+	return i.meta
+}
+
+// setMeta keeps m to write on Close, a flat file is only written whole.
+func (i *Flat) setMeta(m Meta) error {
+	i.meta = m
+	return nil
+}
+
+// sortedInput marks that Insert needs the lines sorted by hash, which
+// Build sees to.
+func (i *Flat) sortedInput() {}
+
+// Insert adds a record to the temporary file. Lines must arrive sorted by
+// hash.
+func (i *Flat) Insert(line string) error {
+	if i.w == nil {
+		return fmt.Errorf("%s is a finished flat index, build a new one", i.opts.Path)
+	}
+	j := strings.Index(line, ":")
+	if j == -1 {
+		return fmt.Errorf("No \":\" in value \"%s\"", line)
+	}
+	hash, err := hex.DecodeString(line[:j])
+	if err != nil {
+		return err
+	}
+	if len(hash) != i.meta.Algorithm.Size() {
+		return fmt.Errorf("hash is %d bytes, want %d", len(hash), i.meta.Algorithm.Size())
+	}
+	if bytes.Compare(hash, i.last) <= 0 {
+		return fmt.Errorf("%s is not after %X, a flat index needs lines sorted by hash", line[:j], i.last)
+	}
+	count, err := parseCount([]byte(line[j+1:]))
+	if err != nil {
+		return err
+	}
+	if count < 0 || count > math.MaxUint32 {
+		return fmt.Errorf("count %d does not fit in a record", count)
+	}
+	rec := make([]byte, i.reclen)
+	copy(rec, hash)
+	binary.BigEndian.PutUint32(rec[len(hash):], uint32(count))
+	if _, err := i.w.Write(rec); err != nil {
+		return err
+	}
+	i.counts[i.prefix(hash)]++
+	i.last = hash
+	return nil
+}
+
+// Lookup binary searches the records between the table entries of the
+// prefix of h.
+func (i *Flat) Lookup(h string) (int, error) {
+	if i.records == nil && i.table == nil {
+		return 0, ErrNotFound
+	}
+	want, err := hex.DecodeString(h)
+	if err != nil {
+		return 0, err
+	}
+	size := i.meta.Algorithm.Size()
+	if len(want) != size {
+		return 0, ErrNotFound
+	}
+	p := i.prefix(want)
+	lo, hi := int(i.entry(p)), int(i.entry(p+1))
+	j := lo + sort.Search(hi-lo, func(j int) bool {
+		rec := i.records[(lo+j)*i.reclen:]
+		return bytes.Compare(rec[:size], want) >= 0
+	})
+	if j == hi {
+		return 0, ErrNotFound
+	}
+	rec := i.records[j*i.reclen : (j+1)*i.reclen]
+	if !bytes.Equal(rec[:size], want) {
+		return 0, ErrNotFound
+	}
+	return int(binary.BigEndian.Uint32(rec[size:])), nil
+}
+
+// readKey returns the records between the table entries of the prefix of
+// h.
+func (i *Flat) readKey(h string) ([]keyRecord, error) {
+	if i.table == nil {
+		return nil, nil
+	}
+	want, err := hex.DecodeString(h)
+	if err != nil {
+		return nil, err
+	}
+	if len(want) != i.meta.Algorithm.Size() {
+		return nil, nil
+	}
+	size := len(want)
+	p := i.prefix(want)
+	lo, hi := int(i.entry(p)), int(i.entry(p+1))
+	recs := make([]keyRecord, 0, hi-lo)
+	for j := lo; j < hi; j++ {
+		rec := i.records[j*i.reclen : (j+1)*i.reclen]
+		recs = append(recs, keyRecord{rec[:size], int(binary.BigEndian.Uint32(rec[size:]))})
+	}
+	return recs, nil
+}
+
+// Walk runs through the records in order.
+func (i *Flat) Walk(fn func(h string, count int) error) error {
+	size := i.meta.Algorithm.Size()
+	for j := 0; j+i.reclen <= len(i.records); j += i.reclen {
+		rec := i.records[j : j+i.reclen]
+		if err := fn(strings.ToUpper(hex.EncodeToString(rec[:size])), int(binary.BigEndian.Uint32(rec[size:]))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Check checks that the offset table only goes up and that the records
+// between two entries are sorted and have the prefix of the first.
+func (i *Flat) Check(problem func(Problem)) (CheckResult, error) {
+	var res CheckResult
+	size := i.meta.Algorithm.Size()
+	entries := len(i.table)/8 - 1
+	for p := 0; p < entries; p++ {
+		lo, hi := i.entry(p), i.entry(p+1)
+		if lo == hi {
+			continue
+		}
+		res.Keys++
+		key := fmt.Sprintf("%0*X", i.meta.PrefixLen, p)
+		kc := newKeyChecker(key, true, problem)
+		if hi < lo || hi*uint64(i.reclen) > uint64(len(i.records)) {
+			kc.fault("table entries %d to %d are out of order", lo, hi)
+			continue
+		}
+		for j := lo; j < hi; j++ {
+			res.Records++
+			rec := i.records[j*uint64(i.reclen) : (j+1)*uint64(i.reclen)]
+			kc.record(strings.ToUpper(hex.EncodeToString(rec[:size])), []byte(fmt.Sprint(binary.BigEndian.Uint32(rec[size:]))))
+		}
+	}
+	return res, nil
+}
+
+// Close writes the flat file of a new index, or unmaps a finished one. A
+// build that did not finish is thrown away.
+func (i *Flat) Close() error {
+	if i.w == nil {
+		return munmap(i.data)
+	}
+	defer os.Remove(i.tmp.Name())
+	err := i.w.Flush()
+	if cerr := i.tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	if i.meta.Checkpoint != nil {
+		return errors.New("the flat index was not written, its build did not finish")
+	}
+	return i.write()
+}
+
+// write puts the Meta, the offset table and the records together in the
+// flat file.
+func (i *Flat) write() error {
+	meta, err := json.Marshal(i.meta)
+	if err != nil {
+		return err
+	}
+	fh, err := os.Create(i.opts.Path + ".new")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(fh.Name())
+	defer fh.Close()
+	w := bufio.NewWriter(fh)
+	w.Write(flatMagic)
+	binary.Write(w, binary.BigEndian, uint32(len(meta)))
+	w.Write(meta)
+	hlen := len(flatMagic) + 4 + len(meta)
+	w.Write(make([]byte, align8(hlen)-hlen))
+	var n uint64
+	for _, c := range i.counts {
+		binary.Write(w, binary.BigEndian, n)
+		n += c
+	}
+	binary.Write(w, binary.BigEndian, n)
+	recs, err := os.Open(i.tmp.Name())
+	if err != nil {
+		return err
+	}
+	defer recs.Close()
+	if _, err := io.Copy(w, recs); err != nil {
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if err := fh.Sync(); err != nil {
+		return err
+	}
+	if err := fh.Close(); err != nil {
+		return err
+	}
+	return os.Rename(fh.Name(), i.opts.Path)
+}
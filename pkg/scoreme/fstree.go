@@ -0,0 +1,319 @@
+package scoreme
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// FSTree is an Index stored as a directory tree. The hash prefix is split
+// into SplitLen sized directory names and the records sharing a prefix are
+// appended, one per line, to a file named v in the leaf directory.
+// The Meta is kept in meta.json at the root.
+type FSTree struct {
+	opts   Options
+	splitN func(string) []string
+	meta   Meta
+	stored bool
+}
+
+// NewFSTree returns the tree rooted at o.Path.
+func NewFSTree(o Options) (*FSTree, error) {
+	t := &FSTree{opts: o}
+	var stored *Meta
+	dat, err := ioutil.ReadFile(t.metaPath())
+	switch {
+	case err == nil:
+		stored = &Meta{}
+		if err := json.Unmarshal(dat, stored); err != nil {
+			return nil, fmt.Errorf("%s: %s", t.metaPath(), err)
+		}
+		t.stored = true
+	case !os.IsNotExist(err):
+		return nil, err
+	case !o.Create && !Exists(o.Path):
+		return nil, fmt.Errorf("%s: no such index", o.Path)
+	}
+	has, err := t.hasRecords()
+	if err != nil {
+		return nil, err
+	}
+	if stored == nil && has {
+		t.meta, err = stampedMeta("fstree", o)
+	} else {
+		t.meta, err = resolveMeta("fstree", stored, o)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", o.Path, err)
+	}
+	t.opts.PrefixLen, t.opts.SplitLen = t.meta.PrefixLen, t.meta.SplitLen
+	t.splitN = SplitN(t.meta.SplitLen)
+	return t, nil
+}
+
+// hasRecords reports whether the tree holds anything besides its Meta.
+func (t *FSTree) hasRecords() (bool, error) {
+	fis, err := ioutil.ReadDir(t.opts.Path)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	for _, fi := range fis {
+		if fi.Name() != "meta.json" {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (t *FSTree) metaPath() string {
+	return t.opts.Path + "/meta.json"
+}
+
+// Meta describes how the index was built.
+func (t *FSTree) Meta() Meta {
+	return t.meta
+}
+
+func (t *FSTree) setMeta(m Meta) error {
+	if err := os.MkdirAll(t.opts.Path, 0744); err != nil {
+		return err
+	}
+	dat, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(t.metaPath(), append(dat, '\n'), 0644); err != nil {
+		return err
+	}
+	t.meta, t.stored = m, true
+	return nil
+}
+
+func (t *FSTree) path(h string) (string, error) {
+	if uint(len(h)) < t.opts.PrefixLen {
+		return "", fmt.Errorf("%s is shorter than the prefix length %d", h, t.opts.PrefixLen)
+	}
+	p := t.splitN(h[:t.opts.PrefixLen])
+	return t.opts.Path + "/" + strings.Join(p, "/"), nil
+}
+
+// Insert appends the record to the file for its prefix.
+func (t *FSTree) Insert(line string) error {
+	line = strings.TrimSpace(line)
+	path, err := t.path(line)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(path, 0744); err != nil {
+		return err
+	}
+	if !t.stored {
+		if err := t.setMeta(t.meta); err != nil {
+			return err
+		}
+	}
+	fh, err := os.OpenFile(path+"/v", os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := fh.Write([]byte(line + "\n")); err != nil {
+		fh.Close()
+		return err
+	}
+	return fh.Close()
+}
+
+// merge rewrites the v file of each group, replacing the count of the
+// records it holds and appending the new ones. A file is replaced whole,
+// and merging it again changes nothing, so a merge stopped between files
+// can be resumed.
+func (t *FSTree) merge(groups []group, meta func(s BuildStats) Meta) error {
+	var s BuildStats
+	size := t.meta.Algorithm.Size()
+	for _, g := range groups {
+		path, err := t.path(g.prefix)
+		if err != nil {
+			return err
+		}
+		updates := make(map[string]string)
+		var order []string
+		err = eachRecord(g.val, size, func(hash, count []byte) error {
+			h := strings.ToUpper(hex.EncodeToString(hash))
+			if _, ok := updates[h]; !ok {
+				order = append(order, h)
+			}
+			updates[h] = string(count)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		dat, err := ioutil.ReadFile(path + "/v")
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		var buf bytes.Buffer
+		p := bufio.NewScanner(bytes.NewReader(dat))
+		for p.Scan() {
+			l := strings.TrimSpace(p.Text())
+			if l == "" {
+				continue
+			}
+			i := strings.LastIndex(l, ":")
+			if i == -1 {
+				return fmt.Errorf("%s/v: No \":\" in record \"%s\"", path, l)
+			}
+			if c, ok := updates[strings.ToUpper(l[:i])]; ok {
+				delete(updates, strings.ToUpper(l[:i]))
+				if sameCount([]byte(l[i+1:]), []byte(c)) {
+					s.Unchanged++
+				} else {
+					s.Changed++
+					l = l[:i+1] + c
+				}
+			}
+			buf.WriteString(l + "\n")
+		}
+		for _, h := range order {
+			if c, ok := updates[h]; ok {
+				s.Added++
+				buf.WriteString(h + ":" + c + "\n")
+			}
+		}
+		if err := os.MkdirAll(path, 0744); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(path+"/v.new", buf.Bytes(), 0644); err != nil {
+			return err
+		}
+		if err := os.Rename(path+"/v.new", path+"/v"); err != nil {
+			return err
+		}
+	}
+	return t.setMeta(meta(s))
+}
+
+// Lookup scans the file for the prefix of h.
+func (t *FSTree) Lookup(h string) (int, error) {
+	path, err := t.path(h)
+	if err != nil {
+		return 0, err
+	}
+	path += "/v"
+	if !Exists(path) {
+		return 0, ErrNotFound
+	}
+	dat, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	p := bufio.NewScanner(bytes.NewReader(dat))
+	for p.Scan() {
+		k, count, err := parseRecord(strings.TrimSpace(p.Text()))
+		if err != nil {
+			return 0, err
+		}
+		if t.opts.Debug {
+			log.Printf("compare \"%s\" to \"%s\"\n", h, k)
+		}
+		if k == h {
+			return count, nil
+		}
+	}
+	if err := p.Err(); err != nil {
+		return 0, err
+	}
+	return 0, ErrNotFound
+}
+
+// Walk reads the v file of every leaf directory.
+func (t *FSTree) Walk(fn func(h string, count int) error) error {
+	return filepath.Walk(t.opts.Path, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || info.Name() != "v" {
+			return nil
+		}
+		fh, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer fh.Close()
+		p := bufio.NewScanner(fh)
+		for p.Scan() {
+			h, count, err := parseRecord(strings.TrimSpace(p.Text()))
+			if err != nil {
+				return fmt.Errorf("%s: %s", path, err)
+			}
+			if err := fn(h, count); err != nil {
+				return err
+			}
+		}
+		return p.Err()
+	})
+}
+
+// Check checks that every v file is in a directory named after a whole
+// prefix and holds records of whole hashes sharing that prefix, none
+// repeated.
+func (t *FSTree) Check(problem func(Problem)) (CheckResult, error) {
+	var res CheckResult
+	hexlen := 2 * t.meta.Algorithm.Size()
+	err := filepath.Walk(t.opts.Path, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || info.Name() != "v" {
+			return nil
+		}
+		res.Keys++
+		rel, err := filepath.Rel(t.opts.Path, filepath.Dir(path))
+		if err != nil {
+			return err
+		}
+		dirs := strings.Split(filepath.ToSlash(rel), "/")
+		kc := newKeyChecker(strings.ToUpper(strings.Join(dirs, "")), false, problem)
+		if uint(len(kc.key)) != t.meta.PrefixLen || strings.Join(t.splitN(kc.key), "/") != strings.ToUpper(strings.Join(dirs, "/")) {
+			kc.fault("%s is not a prefix of %d split every %d", rel, t.meta.PrefixLen, t.meta.SplitLen)
+		}
+		fh, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer fh.Close()
+		p := bufio.NewScanner(fh)
+		for p.Scan() {
+			res.Records++
+			l := strings.TrimSpace(p.Text())
+			var count []byte
+			i := strings.LastIndex(l, ":")
+			if i == -1 {
+				i = len(l)
+			} else {
+				count = []byte(l[i+1:])
+			}
+			kc.record(strings.ToUpper(l[:i]), count)
+			if i != hexlen {
+				kc.fault("hash is %d hex characters, want %d", i, hexlen)
+			}
+		}
+		return p.Err()
+	})
+	return res, err
+}
+
+// Close does nothing, every Insert is written straight to disk.
+func (t *FSTree) Close() error {
+	return nil
+}
@@ -0,0 +1,159 @@
+// Package scoreme scores candidate passwords against an index of pwned
+// password hashes such as the one published by haveibeenpwned.com.
+package scoreme
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// HASHLEN is the length in bytes of a SHA-1 hash.
+	HASHLEN = 20
+	// RECORDLEN is the length of one SHA-1 record in a BoltBatch value: the
+	// raw hash, a colon, a space padded count and a newline. Other
+	// algorithms have their own, see Algorithm.RecordLen.
+	RECORDLEN = 42
+)
+
+// ErrNotFound is returned by Lookup when a hash is not in the index.
+var ErrNotFound = errors.New("hash not found")
+
+// Index is a store of pwned password hashes keyed on a hash prefix.
+type Index interface {
+	// Lookup returns the breach count for the uppercase hex hash h.
+	Lookup(h string) (int, error)
+	// Insert adds one "HASH:count" line of a passwd file to the index.
+	Insert(line string) error
+	// Meta describes how the index was built.
+	Meta() Meta
+	// Close flushes anything pending and releases the index.
+	Close() error
+}
+
+// Walker is implemented by indexes that can list every record they hold.
+type Walker interface {
+	// Walk calls fn for each record in key order, stopping at the first
+	// error.
+	Walk(fn func(h string, count int) error) error
+}
+
+// Options describe where an index lives and how it is keyed. The keying
+// options only matter for a new index: an existing one uses those in its
+// Meta and fails to open if they disagree with the options.
+type Options struct {
+	// Path is the tree directory, the bolt database or the flat file.
+	Path string
+	// Bucket is the bolt bucket holding the records.
+	Bucket string
+	// PrefixLen is the number of hex characters of the hash used as key,
+	// by default 4 for boltbatch and 8 otherwise.
+	PrefixLen uint
+	// SplitLen is the length of each directory name in the fs tree, by
+	// default 2.
+	SplitLen uint
+	// Algorithm is the hash function, by default SHA1.
+	Algorithm Algorithm
+	// Create opens the index to build it, making a new one if there is
+	// none. Without it nothing is written to the index.
+	Create bool
+	// Stamp lets an index built before indexes kept a Meta be opened,
+	// taking it to be keyed as the options say. Such an index fails to
+	// open without it, see Stamp.
+	Stamp bool
+	Debug bool
+}
+
+// Backends lists the names accepted by Open.
+var Backends = []string{"fstree", "bolt", "boltbatch", "flat"}
+
+// Open opens the index of the named backend.
+func Open(backend string, o Options) (Index, error) {
+	switch backend {
+	case "fstree":
+		return NewFSTree(o)
+	case "bolt":
+		return OpenBolt(o)
+	case "boltbatch":
+		return OpenBoltBatch(o)
+	case "flat":
+		return OpenFlat(o)
+	}
+	return nil, fmt.Errorf("unknown backend %q, want one of %s", backend, strings.Join(Backends, ", "))
+}
+
+// Exists checks if a file or directory exists.
+func Exists(path string) bool {
+	if path == "" {
+		return false
+	}
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// Hash returns the uppercase hex SHA-1 of a candidate password, see also
+// Algorithm.Hash.
+func Hash(p []byte) string {
+	return fmt.Sprintf("%X", sha1.Sum(p))
+}
+
+// parseRecord splits a "HASH:count" record into its parts.
+func parseRecord(rec string) (string, int, error) {
+	i := strings.LastIndex(rec, ":")
+	if i == -1 {
+		return "", 0, fmt.Errorf("No \":\" in record \"%s\"", rec)
+	}
+	count, err := parseCount([]byte(rec[i+1:]))
+	return rec[:i], count, err
+}
+
+func parseCount(b []byte) (int, error) {
+	return strconv.Atoi(strings.TrimSpace(string(b)))
+}
+
+// sameCount reports whether the count parts of two records hold the same
+// number, however they are padded.
+func sameCount(a, b []byte) bool {
+	x, err := parseCount(a)
+	if err != nil {
+		return false
+	}
+	y, err := parseCount(b)
+	return err == nil && x == y
+}
+
+// Load inserts every line read from r into idx and returns the number of
+// records inserted. After each batch of n lines progress, if not nil, is
+// called with the time the batch took.
+func Load(idx Index, r io.Reader, n int, progress func(n int, elapsed time.Duration)) (int64, error) {
+	p := bufio.NewScanner(r)
+	b := n
+	var count int64
+	start := time.Now()
+	for p.Scan() {
+		if b <= 0 {
+			if progress != nil {
+				progress(n, time.Since(start))
+			}
+			b = n
+			start = time.Now()
+		}
+		l := strings.TrimRight(p.Text(), "\r\n")
+		if l == "" {
+			continue
+		}
+		if err := idx.Insert(l); err != nil {
+			return count, err
+		}
+		count++
+		b--
+	}
+	return count, p.Err()
+}
@@ -0,0 +1,140 @@
+package scoreme
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// Input formats of a submission, see Scorer.Input.
+const (
+	// InputPlain is one candidate password per line.
+	InputPlain = "plain"
+	// InputPotfile is a hashcat potfile of "hash:password" lines.
+	InputPotfile = "potfile"
+	// InputJohn is a John the Ripper pot file, where the hash has a
+	// "$tag$" in front such as "$NT$" or "$dynamic_26$".
+	InputJohn = "john"
+	// InputHash is one hex hash per line, of the algorithm of the index,
+	// so the passwords are never sent. It is not picked by InputAuto.
+	InputHash = "hash"
+	// InputAuto picks plain, potfile or john for every line, so blank
+	// lines or a mix of formats don't throw it off.
+	InputAuto = "auto"
+)
+
+// Inputs lists the input formats.
+var Inputs = []string{InputAuto, InputPlain, InputPotfile, InputJohn, InputHash}
+
+// CheckInput returns an error for an unknown input format.
+func CheckInput(input string) error {
+	for _, in := range Inputs {
+		if input == in {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown input %q, want one of %s", input, strings.Join(Inputs, ", "))
+}
+
+// lineDecoder returns the hash to score for a submitted line, and false if
+// the line is rejected.
+type lineDecoder func(t []byte) (string, bool)
+
+// decoder returns the lineDecoder of the input format of the Scorer.
+func (s *Scorer) decoder() lineDecoder {
+	alg := s.Index.Meta().Algorithm
+	if s.Input != InputAuto {
+		return inputDecoder(alg, s.Input)
+	}
+	decs := make(map[string]lineDecoder)
+	for _, input := range []string{InputPlain, InputPotfile, InputJohn} {
+		decs[input] = inputDecoder(alg, input)
+	}
+	return func(t []byte) (string, bool) {
+		return decs[detectInput(alg, t)](t)
+	}
+}
+
+// inputDecoder returns the lineDecoder of the input format input.
+func inputDecoder(alg Algorithm, input string) lineDecoder {
+	switch input {
+	case InputPotfile, InputJohn:
+		return func(t []byte) (string, bool) {
+			return potHash(alg, t)
+		}
+	case InputHash:
+		return func(t []byte) (string, bool) {
+			t = bytes.TrimSpace(t)
+			if len(t) != 2*alg.Size() || !isHex(t) {
+				return "", false
+			}
+			return strings.ToUpper(string(t)), true
+		}
+	}
+	return func(t []byte) (string, bool) {
+		return alg.Hash(t), true
+	}
+}
+
+// detectInput tells the format of a submitted line.
+func detectInput(alg Algorithm, t []byte) string {
+	if _, rest, ok := johnTag(t); ok && bytes.IndexByte(rest, ':') != -1 {
+		return InputJohn
+	}
+	n := 2 * alg.Size()
+	if len(t) > n && t[n] == ':' && isHex(t[:n]) {
+		return InputPotfile
+	}
+	return InputPlain
+}
+
+// johnTag splits the "$tag$" John puts in front of a hash from t.
+func johnTag(t []byte) (tag, rest []byte, ok bool) {
+	if len(t) < 3 || t[0] != '$' {
+		return nil, t, false
+	}
+	i := bytes.IndexByte(t[1:], '$')
+	if i <= 0 {
+		return nil, t, false
+	}
+	return t[:i+2], t[i+2:], true
+}
+
+// potHash returns the claimed hash of a potfile line, if the password on
+// the line hashes to it. The password may be $HEX[...] encoded, as
+// hashcat and John write passwords with colons or odd bytes.
+func potHash(alg Algorithm, t []byte) (string, bool) {
+	_, t, _ = johnTag(t)
+	i := bytes.IndexByte(t, ':')
+	if i != 2*alg.Size() || !isHex(t[:i]) {
+		return "", false
+	}
+	claimed := strings.ToUpper(string(t[:i]))
+	h := alg.Hash(decodeHexPlain(t[i+1:]))
+	return h, h == claimed
+}
+
+// decodeHexPlain decodes a $HEX[...] password, leaving anything else as
+// it is.
+func decodeHexPlain(p []byte) []byte {
+	if !bytes.HasPrefix(p, []byte("$HEX[")) || !bytes.HasSuffix(p, []byte("]")) {
+		return p
+	}
+	b, err := hex.DecodeString(string(p[5 : len(p)-1]))
+	if err != nil {
+		return p
+	}
+	return b
+}
+
+func isHex(b []byte) bool {
+	for _, c := range b {
+		switch {
+		case '0' <= c && c <= '9', 'a' <= c && c <= 'f', 'A' <= c && c <= 'F':
+		default:
+			return false
+		}
+	}
+	return true
+}
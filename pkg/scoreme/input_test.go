@@ -0,0 +1,102 @@
+package scoreme
+
+import (
+	"bufio"
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPotHash(t *testing.T) {
+	pw := Hash([]byte("password"))
+	colon := Hash([]byte("pass:word"))
+	empty := Hash([]byte(""))
+	ntlm := NTLM.Hash([]byte("password"))
+	tests := []struct {
+		alg  Algorithm
+		line string
+		want string
+		ok   bool
+	}{
+		{SHA1, pw + ":password", pw, true},
+		{SHA1, strings.ToLower(pw) + ":password", pw, true},
+		{SHA1, pw + ":Password", pw, false},
+		{SHA1, colon + ":pass:word", colon, true},
+		{SHA1, colon + ":$HEX[706173733a776f7264]", colon, true},
+		{SHA1, colon + ":$HEX[706173733A776F7264]", colon, true},
+		{SHA1, empty + ":$HEX[]", empty, true},
+		{SHA1, empty + ":", empty, true},
+		// Bad hex is taken as the password itself.
+		{SHA1, Hash([]byte("$HEX[zz]")) + ":$HEX[zz]", Hash([]byte("$HEX[zz]")), true},
+		{SHA1, Hash([]byte("$HEX[7]")) + ":$HEX[7]", Hash([]byte("$HEX[7]")), true},
+		{SHA1, pw[1:] + ":password", "", false},
+		{SHA1, "Z" + pw[1:] + ":password", "", false},
+		{SHA1, pw, "", false},
+		{SHA1, "$dynamic_26$" + strings.ToLower(pw) + ":password", pw, true},
+		{NTLM, "$NT$" + strings.ToLower(ntlm) + ":password", ntlm, true},
+		{NTLM, ntlm + ":password", ntlm, true},
+		{NTLM, pw + ":password", "", false},
+	}
+	for _, tc := range tests {
+		got, ok := potHash(tc.alg, []byte(tc.line))
+		if ok != tc.ok || (ok && got != tc.want) {
+			t.Errorf("%s %q: got %s, %v, want %s, %v", tc.alg, tc.line, got, ok, tc.want, tc.ok)
+		}
+	}
+}
+
+func TestDetectInput(t *testing.T) {
+	pw := Hash([]byte("password"))
+	ntlm := NTLM.Hash([]byte("password"))
+	tests := []struct {
+		alg  Algorithm
+		line string
+		want string
+	}{
+		{SHA1, "password", InputPlain},
+		{SHA1, "", InputPlain},
+		{SHA1, pw, InputPlain},
+		{SHA1, pw + ":password", InputPotfile},
+		{SHA1, strings.ToLower(pw) + ":", InputPotfile},
+		{SHA1, ntlm + ":password", InputPlain},
+		{NTLM, ntlm + ":password", InputPotfile},
+		{NTLM, "$NT$" + ntlm + ":password", InputJohn},
+		{SHA1, "$dynamic_26$" + pw + ":password", InputJohn},
+		{SHA1, "$NT$nocolon", InputPlain},
+		{SHA1, "$$:x", InputPlain},
+	}
+	for _, tc := range tests {
+		if got := detectInput(tc.alg, []byte(tc.line)); got != tc.want {
+			t.Errorf("%s %q: got %s, want %s", tc.alg, tc.line, got, tc.want)
+		}
+	}
+}
+
+func TestScanAutoInput(t *testing.T) {
+	idx := testIndex()
+	pot := func(pw string) string {
+		return Hash([]byte(pw)) + ":" + pw + "\n"
+	}
+	// The blank lines in front don't make the potfile lines plain.
+	sub := "\n\n" + pot("pw1") + pot("pw2") + "pw3\n" + Hash([]byte("pw5")) + ":pw4\n"
+	for _, workers := range []int{1, 4} {
+		s := &Scorer{Index: idx, Rules: DefaultRules, Input: InputAuto, Workers: workers}
+		var hits []int
+		err := s.Scan(context.Background(), bufio.NewScanner(strings.NewReader(sub)), func(l Line) error {
+			if l.Verdict == Hit {
+				hits = append(hits, l.N)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(hits, []int{3, 4, 5}) {
+			t.Errorf("%d workers: got hits on lines %v, want 3, 4 and 5", workers, hits)
+		}
+		if res := s.Result(); res.Rejected != 1 {
+			t.Errorf("%d workers: %d lines rejected, want the mismatched one", workers, res.Rejected)
+		}
+	}
+}
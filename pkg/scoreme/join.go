@@ -0,0 +1,237 @@
+package scoreme
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// JoinOptions tune Scorer.Join.
+type JoinOptions struct {
+	// TempDir is where the submission is sorted, by default os.TempDir().
+	TempDir string
+	// SortMemory is roughly the bytes of lines sorted in memory at a time,
+	// by default 256MB.
+	SortMemory int64
+}
+
+// keyRecord is one record of a key read by readKey.
+type keyRecord struct {
+	hash  []byte
+	count int
+}
+
+// keyReader is implemented by indexes that keep the records under a key
+// sorted by hash, so a join can read every key once.
+type keyReader interface {
+	// readKey returns the records under the key of the uppercase hex hash
+	// h in increasing order of raw hash.
+	readKey(h string) ([]keyRecord, error)
+}
+
+// Join scores every token of sc like Scan, for submissions too big to look
+// up line by line. The hashes of the passwords are sorted on disk, then
+// joined with the index in one pass, reading every key they fall under
+// once, or looking up every distinct hash once if the index can't read its
+// keys in order. The verdicts are those Scan would give, and report gets
+// the lines in the order they were submitted. When ctx is done Join stops
+// and returns its error. If it was tallying by then, the lines before
+// keep their score.
+func (s *Scorer) Join(ctx context.Context, sc *bufio.Scanner, report func(Line) error, o JoinOptions) error {
+	if o.TempDir == "" {
+		o.TempDir = os.TempDir()
+	}
+	tmp, err := ioutil.TempDir(o.TempDir, "scoreme-join")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+	hashes := filepath.Join(tmp, "hashes")
+	if err := s.writeHashes(ctx, sc, hashes); err != nil {
+		return err
+	}
+	sorted, _, err := sortFile(hashes, tmp, o.SortMemory)
+	if err != nil {
+		return err
+	}
+	// The hashes are found in order of hash, then put back in order of
+	// line number to be tallied, so the score adds up exactly as Scan's.
+	name := filepath.Join(tmp, "found")
+	if err := s.join(ctx, sorted, name); err != nil {
+		return err
+	}
+	if sorted, _, err = sortFile(name, tmp, o.SortMemory); err != nil {
+		return err
+	}
+	return eachLine(sorted, func(t string) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		parts := strings.SplitN(t, ":", 3)
+		if len(parts) != 3 {
+			return fmt.Errorf("bad found line %q", t)
+		}
+		n, err := strconv.Atoi(parts[0])
+		if err != nil {
+			return err
+		}
+		var f found
+		switch parts[2] {
+		case "miss":
+			f.miss = true
+		case "filtered":
+			f.miss, f.filtered = true, true
+		default:
+			if f.count, err = strconv.Atoi(parts[2]); err != nil {
+				return err
+			}
+		}
+		l := s.tally(parts[1], f)
+		l.N = n
+		if report != nil {
+			return report(l)
+		}
+		return nil
+	})
+}
+
+// writeHashes writes a "HASH:line" line for every token of sc that is not
+// rejected to the file name, with the line number padded so the lines of
+// a hash sort in order.
+func (s *Scorer) writeHashes(ctx context.Context, sc *bufio.Scanner, name string) error {
+	fh, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(fh)
+	n := 0
+	dec := s.decoder()
+	for sc.Scan() {
+		if err = ctx.Err(); err != nil {
+			break
+		}
+		n++
+		h, ok := dec(sc.Bytes())
+		if !ok {
+			s.reject()
+			continue
+		}
+		fmt.Fprintf(w, "%s:%020d\n", h, n)
+	}
+	if err == nil {
+		err = sc.Err()
+	}
+	if ferr := w.Flush(); err == nil {
+		err = ferr
+	}
+	if cerr := fh.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// join reads the sorted "HASH:line" lines of the file name and finds their
+// hashes in the index, writing a "line:HASH:found" line for each to the
+// file out, where found is the count, miss or filtered.
+func (s *Scorer) join(ctx context.Context, name, out string) error {
+	idx := s.Index
+	if fi, ok := idx.(*FilteredIndex); ok {
+		idx = fi.Index
+	}
+	kr, sortedKeys := idx.(keyReader)
+	plen := int(s.Index.Meta().PrefixLen)
+	fh, err := os.Create(out)
+	if err != nil {
+		return err
+	}
+	defer fh.Close()
+	w := bufio.NewWriter(fh)
+	var (
+		key  string
+		recs []keyRecord
+		j    int
+		prev string
+		f    found
+	)
+	err = eachLine(name, func(t string) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		i := strings.Index(t, ":")
+		if i == -1 {
+			return fmt.Errorf("No \":\" in joined line %q", t)
+		}
+		h := t[:i]
+		n := t[i+1:]
+		var err error
+		if h != prev {
+			prev = h
+			switch pf, ok := s.Index.(prefilter); {
+			case ok && !pf.mayHave(h):
+				f = found{miss: true, filtered: true}
+			case sortedKeys && len(h) >= plen:
+				if h[:plen] != key {
+					if recs, err = kr.readKey(h); err != nil {
+						return err
+					}
+					key, j = h[:plen], 0
+				}
+				raw, err := hex.DecodeString(h)
+				if err != nil {
+					return err
+				}
+				for j < len(recs) && bytes.Compare(recs[j].hash, raw) < 0 {
+					j++
+				}
+				if j < len(recs) && bytes.Equal(recs[j].hash, raw) {
+					f = found{count: recs[j].count}
+				} else {
+					f = found{miss: true}
+				}
+			default:
+				if f = s.lookup(h); f.err != nil {
+					return f.err
+				}
+			}
+		}
+		switch {
+		case f.filtered:
+			fmt.Fprintf(w, "%s:%s:filtered\n", n, h)
+		case f.miss:
+			fmt.Fprintf(w, "%s:%s:miss\n", n, h)
+		default:
+			fmt.Fprintf(w, "%s:%s:%d\n", n, h, f.count)
+		}
+		return nil
+	})
+	if ferr := w.Flush(); err == nil {
+		err = ferr
+	}
+	if cerr := fh.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// eachLine calls fn with every line of the file name.
+func eachLine(name string, fn func(t string) error) error {
+	fh, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer fh.Close()
+	p := bufio.NewScanner(fh)
+	for p.Scan() {
+		if err := fn(p.Text()); err != nil {
+			return err
+		}
+	}
+	return p.Err()
+}
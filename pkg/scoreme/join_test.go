@@ -0,0 +1,87 @@
+package scoreme
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// buildIndex builds an index of the backend holding the hashes of idx.
+func buildIndex(t *testing.T, backend, dir string, idx memIndex) Index {
+	t.Helper()
+	var b strings.Builder
+	for h, count := range idx {
+		fmt.Fprintf(&b, "%s:%d\n", h, count)
+	}
+	passwd := filepath.Join(dir, "passwd")
+	if err := ioutil.WriteFile(passwd, []byte(b.String()), 0644); err != nil {
+		t.Fatal(err)
+	}
+	o := Options{Path: filepath.Join(dir, backend), Bucket: "bucket1", Create: true}
+	built, err := Open(backend, o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = Build(built, passwd, BuildOptions{BatchSize: 100, TempDir: dir})
+	if cerr := built.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	o.Create = false
+	res, err := Open(backend, o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+func TestJoin(t *testing.T) {
+	mem := testIndex()
+	flat := buildIndex(t, "flat", t.TempDir(), mem)
+	defer flat.Close()
+	filter, err := BuildFilter(flat, 0.01)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bb := buildIndex(t, "boltbatch", t.TempDir(), mem)
+	defer bb.Close()
+	indexes := []struct {
+		name string
+		idx  Index
+	}{
+		{"mem", mem},
+		{"flat", flat},
+		{"filtered flat", &FilteredIndex{Index: flat, Filter: filter}},
+		{"boltbatch", bb},
+	}
+	sub := testSubmission(3000)
+	for _, tc := range indexes {
+		want, wantRes, err := scan(t, context.Background(), tc.idx, 1, sub, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s := &Scorer{Index: tc.idx, Rules: DefaultRules}
+		var got []Line
+		// A few KB sorts the submission in many runs.
+		err = s.Join(context.Background(), bufio.NewScanner(strings.NewReader(sub)), func(l Line) error {
+			got = append(got, l)
+			return nil
+		}, JoinOptions{TempDir: t.TempDir(), SortMemory: 4096})
+		if err != nil {
+			t.Fatalf("%s: %s", tc.name, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: the lines of the join differ from those of the scan", tc.name)
+		}
+		if res := s.Result(); !reflect.DeepEqual(res, wantRes) {
+			t.Errorf("%s: got %+v, want %+v", tc.name, res, wantRes)
+		}
+	}
+}
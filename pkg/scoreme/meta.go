@@ -0,0 +1,162 @@
+package scoreme
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// FormatVersion is the newest index layout written by this package.
+// Version 2 stores the records of a boltbatch index compactly, the other
+// backends are still at version 1.
+const FormatVersion = 2
+
+// Meta describes how an index was built. It is stored in the index, so
+// scoring uses the settings the index was built with instead of trusting
+// flags.
+type Meta struct {
+	Version   int       `json:"version"`
+	Backend   string    `json:"backend"`
+	Algorithm Algorithm `json:"algorithm"`
+	Bucket    string    `json:"bucket,omitempty"`
+	PrefixLen uint      `json:"prefixlen"`
+	SplitLen  uint      `json:"splitlen,omitempty"`
+	Records   int64     `json:"records"`
+	Source    *Source   `json:"source,omitempty"`
+	// Built is when the last build or merge finished, or when the index
+	// was stamped or migrated.
+	Built time.Time `json:"built"`
+	// Checkpoint is set while a build is unfinished.
+	Checkpoint *Checkpoint `json:"checkpoint,omitempty"`
+	// Stamped is set when the index was built before indexes kept a Meta
+	// and this one was made from the options afterwards, see Stamp.
+	Stamped bool `json:"stamped,omitempty"`
+}
+
+// Source is the passwd file an index was last built from.
+type Source struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// metaWriter is implemented by the indexes of this package.
+type metaWriter interface {
+	setMeta(m Meta) error
+}
+
+// errNoMeta is returned for an index that holds records but no Meta.
+var errNoMeta = errors.New("the index has records but no metadata, record how it was built once with scoreme migrate -stamp")
+
+// stampedMeta returns the Meta of an index built before indexes kept one,
+// taken from the options if o.Stamp allows it. Such an index has version 1
+// records.
+func stampedMeta(backend string, o Options) (Meta, error) {
+	if !o.Stamp {
+		return Meta{}, errNoMeta
+	}
+	m, err := resolveMeta(backend, nil, o)
+	m.Version, m.Stamped = 1, true
+	return m, err
+}
+
+// Stamp saves a Meta in an index built before indexes kept one, taking it
+// to be keyed as o says, and returns it. The records are counted and Built
+// is the time of the stamp, the rest of the build is not known.
+func Stamp(backend string, o Options) (Meta, error) {
+	if !Exists(o.Path) {
+		return Meta{}, fmt.Errorf("%s: no such index", o.Path)
+	}
+	o.Create, o.Stamp = true, true
+	idx, err := Open(backend, o)
+	if err != nil {
+		return Meta{}, err
+	}
+	defer idx.Close()
+	m := idx.Meta()
+	w, ok := idx.(metaWriter)
+	switch {
+	case !m.Stamped:
+		return m, fmt.Errorf("%s already has metadata", o.Path)
+	case !ok:
+		return m, fmt.Errorf("the %s backend can't record its metadata", backend)
+	}
+	if wk, ok := idx.(Walker); ok {
+		m.Records = 0
+		err := wk.Walk(func(string, int) error {
+			m.Records++
+			return nil
+		})
+		if err != nil {
+			return m, err
+		}
+	}
+	m.Built = time.Now().UTC()
+	return m, w.setMeta(m)
+}
+
+// defaultPrefixLen returns the prefix length of a new index.
+func defaultPrefixLen(backend string) uint {
+	if backend == "boltbatch" || backend == "flat" {
+		return 4
+	}
+	return 8
+}
+
+// resolveMeta checks the options against the metadata stored in an index,
+// which is nil for a new index. It returns the metadata to use.
+func resolveMeta(backend string, stored *Meta, o Options) (Meta, error) {
+	if stored == nil {
+		m := Meta{
+			Version:   1,
+			Backend:   backend,
+			Algorithm: o.Algorithm,
+			PrefixLen: o.PrefixLen,
+		}
+		if m.Algorithm == "" {
+			m.Algorithm = SHA1
+		}
+		if m.PrefixLen == 0 {
+			m.PrefixLen = defaultPrefixLen(backend)
+		}
+		if backend == "boltbatch" {
+			m.Version = FormatVersion
+		}
+		switch backend {
+		case "fstree":
+			m.SplitLen = o.SplitLen
+			if m.SplitLen == 0 {
+				m.SplitLen = 2
+			}
+		case "flat":
+			// The offset table has an entry for every prefix.
+			if m.PrefixLen > maxFlatPrefixLen {
+				return m, fmt.Errorf("the prefix length of a flat index must be at most %d, not %d", maxFlatPrefixLen, m.PrefixLen)
+			}
+		default:
+			m.Bucket = o.Bucket
+			// The prefix is decoded from hex to make the key.
+			if m.PrefixLen%2 != 0 {
+				return m, fmt.Errorf("the prefix length of a %s index must be even, not %d", backend, m.PrefixLen)
+			}
+		}
+		return m, nil
+	}
+	m := *stored
+	switch {
+	case m.Version > FormatVersion:
+		return m, fmt.Errorf("index format %d is newer than this scoreme understands", m.Version)
+	case m.Backend != backend:
+		return m, fmt.Errorf("index was built by the %s backend, not %s", m.Backend, backend)
+	case o.Algorithm != "" && o.Algorithm != m.Algorithm:
+		return m, fmt.Errorf("the index holds %s hashes, not %s", m.Algorithm, o.Algorithm)
+	case o.PrefixLen != 0 && o.PrefixLen != m.PrefixLen:
+		return m, fmt.Errorf("index was built with prefix length %d, not %d", m.PrefixLen, o.PrefixLen)
+	case backend == "fstree" && o.SplitLen != 0 && o.SplitLen != m.SplitLen:
+		return m, fmt.Errorf("index was built with split length %d, not %d", m.SplitLen, o.SplitLen)
+	}
+	if _, err := ParseAlgorithm(string(m.Algorithm)); err != nil {
+		return m, err
+	}
+	return m, nil
+}
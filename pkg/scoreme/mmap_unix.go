@@ -0,0 +1,21 @@
+//go:build !windows
+// +build !windows
+
+package scoreme
+
+import (
+	"os"
+	"syscall"
+)
+
+// mmap maps the first size bytes of fh read only.
+func mmap(fh *os.File, size int) ([]byte, error) {
+	return syscall.Mmap(int(fh.Fd()), 0, size, syscall.PROT_READ, syscall.MAP_SHARED)
+}
+
+func munmap(b []byte) error {
+	if b == nil {
+		return nil
+	}
+	return syscall.Munmap(b)
+}
@@ -0,0 +1,17 @@
+package scoreme
+
+import (
+	"io"
+	"os"
+)
+
+// mmap reads the first size bytes of fh, there is no mapping on windows.
+func mmap(fh *os.File, size int) ([]byte, error) {
+	b := make([]byte, size)
+	_, err := io.ReadFull(fh, b)
+	return b, err
+}
+
+func munmap(b []byte) error {
+	return nil
+}
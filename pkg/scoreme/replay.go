@@ -0,0 +1,90 @@
+package scoreme
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// Replayed is the score of a team recomputed by Replay, along with the
+// score that was recorded during the competition.
+type Replayed struct {
+	Team
+	Recorded Team `json:"recorded"`
+}
+
+// Changed reports whether the replay disagrees with the recorded score.
+func (r Replayed) Changed() bool {
+	a, b := r.Team, r.Recorded
+	return a.Score != b.Score || a.Bonus != b.Bonus || a.Hits != b.Hits ||
+		a.Misses != b.Misses || a.Duplicates != b.Duplicates
+}
+
+// Replay rescores every submission logged in the competition state at path
+// against idx with rules, in the order they were made. The state is only
+// read, but a server holding it open must be stopped first.
+func Replay(path string, idx Index, rules Rules) ([]Replayed, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{ReadOnly: true, Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	defer db.Close()
+
+	scorers := make(map[string]*Scorer)
+	recorded := make(map[string]Team)
+	err = db.View(func(tx *bolt.Tx) error {
+		tb, sb := tx.Bucket(teamsBucket), tx.Bucket(submissionsBucket)
+		if tb == nil || sb == nil {
+			return fmt.Errorf("%s is not a competition state", path)
+		}
+		err := tb.ForEach(func(k, v []byte) error {
+			var rec teamRecord
+			if err := json.Unmarshal(v, &rec); err != nil {
+				return fmt.Errorf("team %s: %s", k, err)
+			}
+			recorded[rec.Name] = newTeam(rec.Name, rec.Result)
+			scorers[rec.Name] = &Scorer{Index: idx, Rules: rules}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		// Keys are big endian ids, so the cursor runs in submission order.
+		return sb.ForEach(func(k, v []byte) error {
+			var sub Submission
+			if err := json.Unmarshal(v, &sub); err != nil {
+				return fmt.Errorf("submission %x: %s", k, err)
+			}
+			s, ok := scorers[sub.Team]
+			if !ok {
+				s = &Scorer{Index: idx, Rules: rules}
+				scorers[sub.Team] = s
+			}
+			for _, l := range sub.Lines {
+				f := s.lookup(l.Hash)
+				if f.err != nil {
+					return fmt.Errorf("submission %d line %d: %s", sub.ID, l.N, f.err)
+				}
+				f.hashOnly = l.HashOnly
+				s.tally(l.Hash, f)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var teams []Team
+	for name, s := range scorers {
+		teams = append(teams, newTeam(name, s.Result()))
+	}
+	rank(teams)
+	res := make([]Replayed, len(teams))
+	for i, t := range teams {
+		res[i] = Replayed{Team: t, Recorded: recorded[t.Name]}
+	}
+	return res, nil
+}
@@ -0,0 +1,100 @@
+package scoreme
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// ReportFormats lists the formats accepted by NewReport.
+var ReportFormats = []string{"table", "csv", "json"}
+
+// Report writes the explanation of every scored line.
+type Report interface {
+	Write(l Line) error
+	// Close finishes the report. It does not close the underlying writer.
+	Close() error
+}
+
+// NewReport returns a Report writing to w in the named format: an aligned
+// human readable table, CSV with a header row, or a JSON array.
+func NewReport(format string, w io.Writer) (Report, error) {
+	switch format {
+	case "table":
+		t := &tableReport{w: tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)}
+		fmt.Fprintf(t.w, "LINE\tHASH\tVERDICT\tCOUNT\tPOINTS\tBONUS\n")
+		return t, nil
+	case "csv":
+		c := &csvReport{w: csv.NewWriter(w)}
+		c.w.Write([]string{"line", "hash", "verdict", "count", "points", "bonus"})
+		return c, nil
+	case "json":
+		return &jsonReport{w: w}, nil
+	}
+	return nil, fmt.Errorf("unknown report format %q, want one of %s", format, strings.Join(ReportFormats, ", "))
+}
+
+type tableReport struct {
+	w *tabwriter.Writer
+}
+
+func (t *tableReport) Write(l Line) error {
+	_, err := fmt.Fprintf(t.w, "%d\t%s\t%s\t%d\t%+d\t%.2f\n", l.N, l.Hash, l.Verdict, l.Count, l.Points, l.Bonus)
+	return err
+}
+
+func (t *tableReport) Close() error {
+	return t.w.Flush()
+}
+
+type csvReport struct {
+	w *csv.Writer
+}
+
+func (c *csvReport) Write(l Line) error {
+	return c.w.Write([]string{
+		strconv.Itoa(l.N),
+		l.Hash,
+		l.Verdict.String(),
+		strconv.Itoa(l.Count),
+		strconv.Itoa(l.Points),
+		strconv.FormatFloat(float64(l.Bonus), 'f', -1, 32),
+	})
+}
+
+func (c *csvReport) Close() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+type jsonReport struct {
+	w io.Writer
+	n int
+}
+
+func (j *jsonReport) Write(l Line) error {
+	b, err := json.Marshal(l)
+	if err != nil {
+		return err
+	}
+	sep := ",\n"
+	if j.n == 0 {
+		sep = "[\n"
+	}
+	j.n++
+	_, err = fmt.Fprintf(j.w, "%s%s", sep, b)
+	return err
+}
+
+func (j *jsonReport) Close() error {
+	end := "\n]\n"
+	if j.n == 0 {
+		end = "[]\n"
+	}
+	_, err := io.WriteString(j.w, end)
+	return err
+}
@@ -0,0 +1,200 @@
+package scoreme
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// What happens to the score when scoring runs out of time.
+const (
+	// TimeoutZero gives no points at all.
+	TimeoutZero = "zero"
+	// TimeoutPartial keeps the points of the passwords scored so far.
+	TimeoutPartial = "partial"
+)
+
+// Rules decide how many points each candidate password is worth. They
+// are usually loaded from a TOML file, see LoadRules.
+type Rules struct {
+	// Hit is awarded for each new valid hash.
+	Hit int `toml:"hit"`
+	// Miss is awarded for a hash that is not in the index.
+	Miss int `toml:"miss"`
+	// Duplicate is awarded for a hash that already scored.
+	Duplicate int `toml:"duplicate"`
+	// Bonus rewards hits on rarely breached passwords.
+	Bonus Bonus `toml:"bonus"`
+	// Timeout is TimeoutZero or TimeoutPartial.
+	Timeout string `toml:"timeout"`
+	// HashCredit gives hits on hashes submitted without their password
+	// the bonus of a password. Without it they only earn Hit.
+	HashCredit bool `toml:"hash_credit"`
+}
+
+// Bonus curves, see Bonus.
+const (
+	// CurveInverse is Points/count.
+	CurveInverse = "inverse"
+	// CurveInverseLog is Points/(1+ln(count)), which falls off slower.
+	CurveInverseLog = "inverse-log"
+	// CurveTiered awards the Points of the first tier the count fits.
+	CurveTiered = "tiered"
+)
+
+// Curves lists the bonus curves.
+var Curves = []string{CurveInverse, CurveInverseLog, CurveTiered}
+
+// Bonus is the rarity bonus of a hit on a password seen count times in
+// breaches, computed by the selected Curve and limited to Max if that is
+// not zero.
+type Bonus struct {
+	Curve  string  `toml:"curve"`
+	Points float32 `toml:"points"`
+	Max    float32 `toml:"max"`
+	Tiers  []Tier  `toml:"tiers"`
+}
+
+// Tier is one bucket of the tiered curve: a count of at most Count earns
+// Points.
+type Tier struct {
+	Count  int     `toml:"count"`
+	Points float32 `toml:"points"`
+}
+
+// Value returns the bonus for a breach count.
+func (b Bonus) Value(count int) float32 {
+	if count <= 0 {
+		return 0
+	}
+	var v float32
+	switch b.Curve {
+	case CurveInverse, "":
+		v = b.Points / float32(count)
+	case CurveInverseLog:
+		v = b.Points / float32(1+math.Log(float64(count)))
+	case CurveTiered:
+		for _, t := range b.Tiers {
+			if count <= t.Count {
+				v = t.Points
+				break
+			}
+		}
+	}
+	if b.Max != 0 && v > b.Max {
+		v = b.Max
+	}
+	return v
+}
+
+// Validate checks the curve and its tiers.
+func (b Bonus) Validate() error {
+	switch b.Curve {
+	case CurveInverse, CurveInverseLog:
+	case CurveTiered:
+		if len(b.Tiers) == 0 {
+			return fmt.Errorf("the %s bonus curve needs tiers", b.Curve)
+		}
+		for i := 1; i < len(b.Tiers); i++ {
+			if b.Tiers[i].Count <= b.Tiers[i-1].Count {
+				return fmt.Errorf("bonus tiers must be in increasing order of count")
+			}
+		}
+	default:
+		return fmt.Errorf("bonus curve must be one of %s, not %q", strings.Join(Curves, ", "), b.Curve)
+	}
+	if b.Max < 0 {
+		return fmt.Errorf("bonus max must not be negative")
+	}
+	return nil
+}
+
+// String describes the curve precisely enough to reproduce a score.
+func (b Bonus) String() string {
+	var s string
+	switch b.Curve {
+	case CurveInverseLog:
+		s = fmt.Sprintf("%s %g/(1+ln(count))", b.Curve, b.Points)
+	case CurveTiered:
+		var t []string
+		for _, v := range b.Tiers {
+			t = append(t, fmt.Sprintf("%g if count<=%d", v.Points, v.Count))
+		}
+		s = fmt.Sprintf("%s %s", b.Curve, strings.Join(t, ", "))
+	default:
+		s = fmt.Sprintf("%s %g/count", b.Curve, b.Points)
+	}
+	if b.Max != 0 {
+		s += fmt.Sprintf(" capped at %g", b.Max)
+	}
+	return s
+}
+
+// DefaultRules are used when no rules file is given.
+var DefaultRules = Rules{
+	Hit:       1,
+	Miss:      -1,
+	Duplicate: -1,
+	Bonus:     Bonus{Curve: CurveInverse, Points: 1},
+	Timeout:   TimeoutZero,
+}
+
+// LoadRules reads a TOML rules file. Anything the file leaves out keeps
+// its value from DefaultRules.
+func LoadRules(path string) (Rules, error) {
+	r := DefaultRules
+	md, err := toml.DecodeFile(path, &r)
+	if err != nil {
+		return r, err
+	}
+	if u := md.Undecoded(); len(u) > 0 {
+		return r, fmt.Errorf("%s: unknown rule %q", path, u[0].String())
+	}
+	return r, r.Validate()
+}
+
+// Validate checks that the rules make sense.
+func (r Rules) Validate() error {
+	switch r.Timeout {
+	case TimeoutZero, TimeoutPartial:
+	default:
+		return fmt.Errorf("timeout must be %q or %q, not %q", TimeoutZero, TimeoutPartial, r.Timeout)
+	}
+	return r.Bonus.Validate()
+}
+
+// TimeOut marks res as cut short by a timeout and applies the timeout
+// rule to it. The counts are left as they were, so the result still shows
+// how many lines were scored.
+func (r Rules) TimeOut(res *Result) {
+	res.TimedOut = true
+	res.Timeout = r.Timeout
+	if r.Timeout != TimeoutPartial {
+		res.Score, res.Bonus = 0, 0
+	}
+}
+
+// String explains the rules to contestants.
+func (r Rules) String() string {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "\nThe rules are these:\n")
+	fmt.Fprintf(&b, "1. %+d for missing\n", r.Miss)
+	fmt.Fprintf(&b, "2. %+d points for each valid hash\n", r.Hit)
+	fmt.Fprintf(&b, "3. %+d for duplicate.\n", r.Duplicate)
+	fmt.Fprintf(&b, "4. Add bonus for rare passwords, so %s gets %.2f (%s)\n",
+		"04E2B8C988822005B768843B50A08BABDBA654FD:2", r.Bonus.Value(2), r.Bonus)
+	if r.Timeout == TimeoutPartial {
+		fmt.Fprintf(&b, "5. If timeout happens before scoring you keep the points scored so far.\n")
+	} else {
+		fmt.Fprintf(&b, "5. If timeout happens before scoring you don't get any points.\n")
+	}
+	if r.HashCredit {
+		fmt.Fprintf(&b, "6. Hashes sent without their password score like the password.\n")
+	} else {
+		fmt.Fprintf(&b, "6. Hashes sent without their password get no bonus.\n")
+	}
+	return b.String()
+}
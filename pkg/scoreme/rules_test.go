@@ -0,0 +1,60 @@
+package scoreme
+
+import (
+	"math"
+	"testing"
+)
+
+func TestBonusValue(t *testing.T) {
+	tiers := []Tier{{Count: 10, Points: 5}, {Count: 100, Points: 2}}
+	counts := []int{1, 10, 11, 100, 101}
+	tests := []struct {
+		b    Bonus
+		want []float32
+	}{
+		{Bonus{Curve: CurveInverse, Points: 10}, []float32{10, 1, 10.0 / 11, 0.1, 10.0 / 101}},
+		{Bonus{Points: 10}, []float32{10, 1, 10.0 / 11, 0.1, 10.0 / 101}},
+		{Bonus{Curve: CurveInverse, Points: 10, Max: 2}, []float32{2, 1, 10.0 / 11, 0.1, 10.0 / 101}},
+		{Bonus{Curve: CurveInverseLog, Points: 10}, []float32{10, 3.02793, 2.94300, 1.78407, 1.78091}},
+		{Bonus{Curve: CurveInverseLog, Points: 10, Max: 3}, []float32{3, 3, 2.94300, 1.78407, 1.78091}},
+		{Bonus{Curve: CurveTiered, Tiers: tiers}, []float32{5, 5, 2, 2, 0}},
+		{Bonus{Curve: CurveTiered, Tiers: tiers, Max: 4}, []float32{4, 4, 2, 2, 0}},
+	}
+	for _, tc := range tests {
+		for i, count := range counts {
+			got := tc.b.Value(count)
+			if math.Abs(float64(got-tc.want[i])) > 1e-4 {
+				t.Errorf("%s: count %d got %g, want %g", tc.b, count, got, tc.want[i])
+			}
+		}
+		for _, count := range []int{0, -1} {
+			if got := tc.b.Value(count); got != 0 {
+				t.Errorf("%s: count %d got %g, want 0", tc.b, count, got)
+			}
+		}
+	}
+}
+
+func TestBonusValidate(t *testing.T) {
+	tests := []struct {
+		b  Bonus
+		ok bool
+	}{
+		{Bonus{Curve: CurveInverse, Points: 1}, true},
+		{Bonus{Curve: CurveInverseLog, Points: 1, Max: 2}, true},
+		{Bonus{Curve: CurveTiered, Tiers: []Tier{{10, 5}}}, true},
+		{Bonus{Curve: CurveTiered, Tiers: []Tier{{10, 5}, {100, 2}}}, true},
+		{Bonus{Curve: CurveTiered}, false},
+		{Bonus{Curve: CurveTiered, Tiers: []Tier{{100, 2}, {10, 5}}}, false},
+		{Bonus{Curve: CurveTiered, Tiers: []Tier{{10, 5}, {10, 2}}}, false},
+		{Bonus{Curve: CurveTiered, Tiers: []Tier{{10, 5}, {100, 2}, {50, 1}}}, false},
+		{Bonus{Curve: CurveInverse, Points: 1, Max: -1}, false},
+		{Bonus{Curve: "square"}, false},
+		{Bonus{}, false},
+	}
+	for _, tc := range tests {
+		if err := tc.b.Validate(); (err == nil) != tc.ok {
+			t.Errorf("%+v: got error %v, want ok %v", tc.b, err, tc.ok)
+		}
+	}
+}
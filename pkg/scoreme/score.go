@@ -0,0 +1,423 @@
+package scoreme
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log"
+	"sync"
+)
+
+// Verdict is what happened to one candidate password.
+type Verdict int
+
+const (
+	// Miss is a password that is not in the index.
+	Miss Verdict = iota
+	// Hit is a password in the index that had not scored yet.
+	Hit
+	// Duplicate is a password that already scored.
+	Duplicate
+)
+
+var verdicts = []string{"miss", "hit", "duplicate"}
+
+func (v Verdict) String() string {
+	if v < 0 || int(v) >= len(verdicts) {
+		return fmt.Sprintf("Verdict(%d)", int(v))
+	}
+	return verdicts[v]
+}
+
+// MarshalText encodes the verdict by name.
+func (v Verdict) MarshalText() ([]byte, error) {
+	return []byte(v.String()), nil
+}
+
+// UnmarshalText decodes a verdict name.
+func (v *Verdict) UnmarshalText(b []byte) error {
+	for i, s := range verdicts {
+		if s == string(b) {
+			*v = Verdict(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown verdict %q", b)
+}
+
+// Line explains the score of one submitted line.
+type Line struct {
+	// N is the line number in the submission, starting at 1.
+	N int `json:"line"`
+	// Hash is the SHA-1, or NTLM hash for an NTLM index.
+	Hash    string  `json:"hash"`
+	Verdict Verdict `json:"verdict"`
+	// Count is the breach count of a hit or duplicate.
+	Count  int     `json:"count"`
+	Points int     `json:"points"`
+	Bonus  float32 `json:"bonus"`
+	// HashOnly is set when the hash was submitted without its password,
+	// see Rules.HashCredit.
+	HashOnly bool `json:"hash_only,omitempty"`
+}
+
+// Scorer tallies points for candidate passwords looked up in an Index
+// according to its Rules. It remembers every hit so a password only scores
+// once, and is safe for concurrent use.
+type Scorer struct {
+	Index Index
+	Rules Rules
+	Debug bool
+	// Workers is the number of goroutines Scan looks up passwords with,
+	// one when it is 0 or 1.
+	Workers int
+	// Input is the format of the submitted lines, one of Inputs. It is
+	// InputPlain when empty.
+	Input string
+
+	mu       sync.Mutex
+	hits     map[string]bool
+	score    int
+	bonus    float32
+	counts   [3]int
+	filtered int
+	rejected int
+}
+
+// Result is the outcome of scoring a submission.
+type Result struct {
+	Score      int     `json:"score"`
+	Bonus      float32 `json:"bonus"`
+	Hits       int     `json:"hits"`
+	Misses     int     `json:"misses"`
+	Duplicates int     `json:"duplicates"`
+	// Filtered counts the misses ruled out by the filter of the index
+	// without a lookup.
+	Filtered int `json:"filtered,omitempty"`
+	// Rejected counts the lines that were not scored: potfile lines whose
+	// password does not hash to the hash on the line, and lines that are
+	// not a hash when hashes are submitted.
+	Rejected int `json:"rejected,omitempty"`
+	// Elapsed is the scoring time in seconds.
+	Elapsed  float64 `json:"elapsed"`
+	TimedOut bool    `json:"timed_out"`
+	// Timeout is the timeout rule applied to a result that timed out.
+	Timeout    string     `json:"timeout,omitempty"`
+	BonusCurve string     `json:"bonus_curve"`
+	Index      *IndexInfo `json:"index,omitempty"`
+	Lines      []Line     `json:"lines,omitempty"`
+}
+
+// IndexInfo describes the index a Result was scored against.
+type IndexInfo struct {
+	Path string `json:"path"`
+	// Filter describes the filter in front of the index, if any.
+	Filter *FilterMeta `json:"filter,omitempty"`
+	Meta
+}
+
+// prefilter is implemented by indexes that can rule out a hash without
+// looking it up.
+type prefilter interface {
+	mayHave(h string) bool
+}
+
+// Add hashes the candidate password p with the algorithm of the index and
+// scores it.
+func (s *Scorer) Add(p []byte) (Line, error) {
+	return s.AddHash(s.Index.Meta().Algorithm.Hash(p))
+}
+
+// AddHash scores the uppercase hex hash h.
+func (s *Scorer) AddHash(h string) (Line, error) {
+	f := s.lookup(h)
+	if f.err != nil {
+		return Line{Hash: h}, f.err
+	}
+	return s.tally(h, f), nil
+}
+
+// found is what the index holds for a hash.
+type found struct {
+	count int
+	// miss is set when the hash is not in the index, and filtered when the
+	// filter of the index ruled it out without a lookup. rejected is set
+	// for a line that is not scored, see Result.Rejected, and hashOnly for
+	// a hash submitted without its password.
+	miss, filtered, rejected, hashOnly bool
+	err                                error
+}
+
+// lookup looks h up without touching the score, so it can be called from
+// any goroutine.
+func (s *Scorer) lookup(h string) found {
+	if pf, ok := s.Index.(prefilter); ok && !pf.mayHave(h) {
+		return found{miss: true, filtered: true}
+	}
+	count, err := s.Index.Lookup(h)
+	if err == ErrNotFound {
+		return found{miss: true}
+	}
+	return found{count: count, err: err}
+}
+
+// reject counts a line that is not scored.
+func (s *Scorer) reject() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rejected++
+}
+
+// tally judges a looked up hash and adds its points to the score. A hash
+// submitted without its password only gets the bonus of a hit with
+// Rules.HashCredit.
+func (s *Scorer) tally(h string, f found) Line {
+	l := Line{Hash: h, HashOnly: f.hashOnly || s.Input == InputHash}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.hits == nil {
+		s.hits = make(map[string]bool)
+	}
+	if f.filtered {
+		s.filtered++
+	}
+	switch {
+	case f.miss:
+		l.Verdict = Miss
+		l.Points = s.Rules.Miss
+	case s.hits[h]:
+		l.Verdict = Duplicate
+		l.Count = f.count
+		l.Points = s.Rules.Duplicate
+	default:
+		s.hits[h] = true
+		l.Verdict = Hit
+		l.Count = f.count
+		l.Points = s.Rules.Hit
+		if !l.HashOnly || s.Rules.HashCredit {
+			l.Bonus = s.Rules.Bonus.Value(f.count)
+		}
+	}
+	s.score += l.Points
+	s.bonus += l.Bonus
+	s.counts[l.Verdict]++
+	if s.Debug {
+		log.Printf("%s: %s %d\n", h, l.Verdict, f.count)
+	}
+	return l
+}
+
+// Scan scores every token of sc as a candidate password, passing the
+// explanation of each line to report if it is not nil. With Workers above
+// 1 the lookups are made in parallel, see scanParallel. When ctx is done
+// Scan stops between lines and returns its error, leaving the score of the
+// lines before.
+func (s *Scorer) Scan(ctx context.Context, sc *bufio.Scanner, report func(Line) error) error {
+	if s.Workers > 1 {
+		return s.scanParallel(ctx, sc, report)
+	}
+	n := 0
+	dec := s.decoder()
+	for sc.Scan() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		n++
+		h, ok := dec(sc.Bytes())
+		if !ok {
+			s.reject()
+			continue
+		}
+		l, err := s.AddHash(h)
+		if err != nil {
+			return err
+		}
+		l.N = n
+		if report != nil {
+			if err := report(l); err != nil {
+				return err
+			}
+		}
+	}
+	return sc.Err()
+}
+
+// scanBatch is a run of lines looked up by one worker.
+type scanBatch struct {
+	// first is the line number of the first password.
+	first  int
+	pws    [][]byte
+	hashes []string
+	found  []found
+	// done is closed when the lookups are made.
+	done chan struct{}
+}
+
+// scanBatchSize is the number of lines handed to a worker at a time.
+const scanBatchSize = 1024
+
+// scanParallel reads batches of lines for Workers goroutines to hash and
+// look up, each distinct hash of a batch once. Only the calling goroutine
+// touches the score: it takes the batches in the order they were read and
+// tallies their lines, so hits and duplicates are judged as they would be
+// one line at a time. When ctx is done no more batches are looked up or
+// tallied.
+func (s *Scorer) scanParallel(ctx context.Context, sc *bufio.Scanner, report func(Line) error) error {
+	jobs := make(chan *scanBatch, s.Workers)
+	order := make(chan *scanBatch, 2*s.Workers)
+	quit := make(chan struct{})
+	dec := s.decoder()
+	var wg sync.WaitGroup
+	for w := 0; w < s.Workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				var b *scanBatch
+				select {
+				case b = <-jobs:
+				case <-quit:
+					return
+				}
+				if b == nil {
+					return
+				}
+				seen := make(map[string]found, len(b.pws))
+				for i, pw := range b.pws {
+					if ctx.Err() != nil {
+						break
+					}
+					h, ok := dec(pw)
+					if !ok {
+						b.found[i].rejected = true
+						continue
+					}
+					f, ok := seen[h]
+					if !ok {
+						f = s.lookup(h)
+						seen[h] = f
+					}
+					b.hashes[i], b.found[i] = h, f
+				}
+				close(b.done)
+			}
+		}()
+	}
+	// The reader stops at quit or when ctx is done, but it may be in the
+	// middle of reading sc. It is waited for along with the workers, so
+	// neither sc nor the index is touched once the scan returns.
+	var scanErr error
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(jobs)
+		defer close(order)
+		n := 0
+		for {
+			b := &scanBatch{first: n + 1, done: make(chan struct{})}
+			for len(b.pws) < scanBatchSize && sc.Scan() {
+				b.pws = append(b.pws, append([]byte(nil), sc.Bytes()...))
+			}
+			if len(b.pws) == 0 {
+				scanErr = sc.Err()
+				return
+			}
+			n += len(b.pws)
+			b.hashes = make([]string, len(b.pws))
+			b.found = make([]found, len(b.pws))
+			select {
+			case order <- b:
+			case <-quit:
+				return
+			case <-ctx.Done():
+				scanErr = ctx.Err()
+				return
+			}
+			select {
+			case jobs <- b:
+			case <-quit:
+				return
+			case <-ctx.Done():
+				scanErr = ctx.Err()
+				return
+			}
+		}
+	}()
+	err := s.tallyBatches(ctx, order, report)
+	close(quit)
+	wg.Wait()
+	if err == nil {
+		err = scanErr
+	}
+	return err
+}
+
+// tallyBatches tallies the lines of the batches in the order they come.
+func (s *Scorer) tallyBatches(ctx context.Context, order <-chan *scanBatch, report func(Line) error) error {
+	for b := range order {
+		select {
+		case <-b.done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		for i, h := range b.hashes {
+			if b.found[i].err != nil {
+				return b.found[i].err
+			}
+			if b.found[i].rejected {
+				s.reject()
+				continue
+			}
+			l := s.tally(h, b.found[i])
+			l.N = b.first + i
+			if report != nil {
+				if err := report(l); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// Score returns the points and the bonus so far.
+func (s *Scorer) Score() (int, float32) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.score, s.bonus
+}
+
+// Restore sets the score to a saved result and hits, as when resuming a
+// competition.
+func (s *Scorer) Restore(r Result, hits []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.score, s.bonus = r.Score, r.Bonus
+	s.counts[Hit], s.counts[Miss], s.counts[Duplicate] = r.Hits, r.Misses, r.Duplicates
+	s.filtered = r.Filtered
+	s.rejected = r.Rejected
+	s.hits = make(map[string]bool)
+	for _, h := range hits {
+		s.hits[h] = true
+	}
+}
+
+// Result returns the score so far and how it was made up. Elapsed, TimedOut
+// and Index are left for the caller to fill in.
+func (s *Scorer) Result() Result {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return Result{
+		Score:      s.score,
+		Bonus:      s.bonus,
+		Hits:       s.counts[Hit],
+		Misses:     s.counts[Miss],
+		Duplicates: s.counts[Duplicate],
+		Filtered:   s.filtered,
+		Rejected:   s.rejected,
+		BonusCurve: s.Rules.Bonus.String(),
+	}
+}
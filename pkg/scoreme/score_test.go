@@ -0,0 +1,159 @@
+package scoreme
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// memIndex is an Index of the hashes of the passwords in a map.
+type memIndex map[string]int
+
+func newMemIndex(counts map[string]int) memIndex {
+	m := make(memIndex)
+	for pw, count := range counts {
+		m[Hash([]byte(pw))] = count
+	}
+	return m
+}
+
+func (m memIndex) Lookup(h string) (int, error) {
+	if count, ok := m[h]; ok {
+		return count, nil
+	}
+	return 0, ErrNotFound
+}
+
+func (m memIndex) Insert(line string) error {
+	h, count, err := parseRecord(line)
+	m[h] = count
+	return err
+}
+
+func (m memIndex) Meta() Meta {
+	return Meta{Backend: "mem", Algorithm: SHA1, PrefixLen: 4, Records: int64(len(m))}
+}
+
+func (m memIndex) Close() error {
+	return nil
+}
+
+// testIndex holds pw0 to pw999, pwN seen N+1 times.
+func testIndex() memIndex {
+	counts := make(map[string]int)
+	for i := 0; i < 1000; i++ {
+		counts[fmt.Sprintf("pw%d", i)] = i + 1
+	}
+	return newMemIndex(counts)
+}
+
+// testSubmission returns n lines of hits, misses and duplicates, spread
+// over several batches of scanParallel.
+func testSubmission(n int) string {
+	var b strings.Builder
+	for i := 0; i < n; i++ {
+		switch i % 3 {
+		case 0:
+			fmt.Fprintf(&b, "pw%d\n", i%1500)
+		case 1:
+			fmt.Fprintf(&b, "miss%d\n", i%700)
+		default:
+			fmt.Fprintf(&b, "pw%d\n", i%400)
+		}
+	}
+	return b.String()
+}
+
+// scan scores sub with a new Scorer, returning the lines and the result.
+func scan(t *testing.T, ctx context.Context, idx Index, workers int, sub string, report func(Line) error) ([]Line, Result, error) {
+	t.Helper()
+	s := &Scorer{Index: idx, Rules: DefaultRules, Workers: workers}
+	var lines []Line
+	err := s.Scan(ctx, bufio.NewScanner(strings.NewReader(sub)), func(l Line) error {
+		lines = append(lines, l)
+		if report != nil {
+			return report(l)
+		}
+		return nil
+	})
+	return lines, s.Result(), err
+}
+
+func TestScanWorkers(t *testing.T) {
+	idx := testIndex()
+	sub := testSubmission(5 * scanBatchSize)
+	want, wantRes, err := scan(t, context.Background(), idx, 1, sub, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wantRes.Hits == 0 || wantRes.Misses == 0 || wantRes.Duplicates == 0 {
+		t.Fatalf("the submission should have hits, misses and duplicates, got %+v", wantRes)
+	}
+	for _, workers := range []int{2, 8} {
+		got, res, err := scan(t, context.Background(), idx, workers, sub, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%d workers: the lines differ from those of one", workers)
+		}
+		if !reflect.DeepEqual(res, wantRes) {
+			t.Errorf("%d workers: got %+v, want %+v", workers, res, wantRes)
+		}
+	}
+}
+
+// endless is a submission that never ends. Its reads are not
+// synchronised, so the race detector catches a read after Scan returned.
+type endless struct {
+	n int
+}
+
+func (r *endless) Read(p []byte) (int, error) {
+	line := fmt.Sprintf("pw%d\n", r.n%2000)
+	r.n++
+	return copy(p, line), nil
+}
+
+func TestScanWorkersCancel(t *testing.T) {
+	idx := testIndex()
+	sub := testSubmission(5 * scanBatchSize)
+	want, _, err := scan(t, context.Background(), idx, 1, sub, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stop := 3*scanBatchSize + 10
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	got, _, err := scan(t, ctx, idx, 4, sub, func(l Line) error {
+		if l.N == stop {
+			cancel()
+		}
+		return nil
+	})
+	if err != context.Canceled {
+		t.Fatalf("got error %v, want %v", err, context.Canceled)
+	}
+	if len(got) < stop || !reflect.DeepEqual(got, want[:len(got)]) {
+		t.Errorf("the %d lines scored before the cancel differ from those of one worker", len(got))
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	r := &endless{}
+	s := &Scorer{Index: idx, Rules: DefaultRules, Workers: 4}
+	err = s.Scan(ctx, bufio.NewScanner(r), func(l Line) error {
+		if l.N == stop {
+			cancel()
+		}
+		return nil
+	})
+	if err != context.Canceled {
+		t.Fatalf("got error %v, want %v", err, context.Canceled)
+	}
+	if r.n < stop {
+		t.Errorf("%d lines read, want at least %d", r.n, stop)
+	}
+}
@@ -0,0 +1,72 @@
+package scoreme
+
+import (
+	"bufio"
+	"bytes"
+	"net/url"
+)
+
+// SplitN returns a function that cuts a string into pieces of n
+// characters, the last piece holding whatever is left over.
+func SplitN(n uint) func(string) []string {
+	return func(a string) []string {
+		var res []string
+		var i uint
+		for i = 0; i < uint(len(a)); i += n {
+			if uint(len(a[i:])) < n {
+				res = append(res, a[i:])
+			} else {
+				res = append(res, a[i:i+n])
+			}
+		}
+		return res
+	}
+}
+
+// RecordSplitter returns a bufio.SplitFunc for the records stored by the
+// Bolt index. Each record starts with hashlen raw hash bytes, which may
+// themselves contain newlines, so only a newline after them ends the record.
+func RecordSplitter(hashlen int) bufio.SplitFunc {
+	return func(data []byte, atEOF bool) (advance int, token []byte, err error) {
+		if len(data) > hashlen {
+			if i := bytes.IndexByte(data[hashlen:], '\n'); i >= 0 {
+				i += hashlen
+				return i + 1, data[:i], nil
+			}
+		}
+		if atEOF && len(data) > 0 {
+			return len(data), data, nil
+		}
+		return 0, nil, nil
+	}
+}
+
+const crlfHTML = "%0D%0A"
+
+func unescape(data []byte) ([]byte, error) {
+	s, err := url.QueryUnescape(string(data))
+	if err != nil {
+		return nil, err
+	}
+	return []byte(s), nil
+}
+
+// HTMLBodySplitter is a bufio.SplitFunc that returns the lines of an url
+// encoded textarea value, as posted by the easy mode form.
+func HTMLBodySplitter(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if atEOF && len(data) == 0 {
+		return 0, nil, nil
+	}
+	if i := bytes.Index(data, []byte(crlfHTML)); i >= 0 {
+		// We have a full newline-terminated line.
+		token, err = unescape(data[0:i])
+		return i + len(crlfHTML), token, err
+	}
+	// If we're at EOF, we have a final, non-terminated line. Return it.
+	if atEOF {
+		token, err = unescape(data)
+		return len(data), token, err
+	}
+	// Request more data.
+	return 0, nil, nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/hagna/scoreme/pkg/scoreme"
+)
+
+func init() {
+	var (
+		f      indexFlags
+		rf     rulesFlags
+		format string
+	)
+	c := newCommand("replay", "statefile", "Recompute every team's score from the submissions logged by serve")
+	f.register(c.flags)
+	rf.register(c.flags)
+	rf.usage(c)
+	c.flags.StringVar(&format, "format", "text", "Print the teams as a text table or json.")
+	c.run = func(args []string) int {
+		if code, ok := c.parse(args, 1, 1); !ok {
+			return code
+		}
+		if err := checkFormat(format); err != nil {
+			errorf("%s", err)
+			return exitUsage
+		}
+		rules, err := rf.load()
+		if err != nil {
+			errorf("%s", err)
+			return exitUsage
+		}
+		idx, err := f.openFiltered()
+		if err != nil {
+			errorf("%s", err)
+			return exitFail
+		}
+		defer idx.Close()
+		res, err := scoreme.Replay(c.flags.Arg(0), idx, rules)
+		if err != nil {
+			errorf("%s", err)
+			return exitFail
+		}
+		if format == "json" {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			if err := enc.Encode(res); err != nil {
+				errorf("%s", err)
+				return exitFail
+			}
+			return exitOK
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintf(w, "RANK\tTEAM\tSCORE\tBONUS\tHITS\tMISSES\tDUPLICATES\tRECORDED\n")
+		for _, r := range res {
+			was := "same"
+			if r.Changed() {
+				was = fmt.Sprintf("%d (%.2f)", r.Recorded.Score, r.Recorded.Bonus)
+			}
+			fmt.Fprintf(w, "%d\t%s\t%d\t%.2f\t%d\t%d\t%d\t%s\n",
+				r.Rank, r.Name, r.Score, r.Bonus, r.Hits, r.Misses, r.Duplicates, was)
+		}
+		w.Flush()
+		fmt.Printf("Bonus curve is %s.\n", rules.Bonus)
+		return exitOK
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/hagna/scoreme/pkg/scoreme"
+	"github.com/peterh/liner"
+)
+
+const AUTHHASH = "B84A50AC94A94B9A0A160639AA19DEDD4ABB436A"
+
+func isAuth() bool {
+	s := liner.NewLiner()
+	defer s.Close()
+	p, err := s.PasswordPrompt("Password: ")
+	if err != nil {
+		errorf("%s", err)
+	}
+	p = strings.TrimSpace(p)
+
+	v := fmt.Sprintf("%X", sha1.Sum([]byte(p)))
+	return v == AUTHHASH
+}
+
+// writeResult prints the result as text or as a JSON object.
+func writeResult(w io.Writer, format string, res scoreme.Result) error {
+	if format == "json" {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(res)
+	}
+	_, err := fmt.Fprintf(w, "Score is %d (%.2f).\nBonus curve is %s.\n", res.Score, res.Bonus, res.BonusCurve)
+	if err == nil && res.Rejected > 0 {
+		_, err = fmt.Fprintf(w, "%d lines rejected, their hash is malformed or not that of their password.\n", res.Rejected)
+	}
+	if err == nil && res.TimedOut {
+		_, err = fmt.Fprintf(w, "Timed out after %d lines, scored by the %s timeout rule.\n", res.Hits+res.Misses+res.Duplicates, res.Timeout)
+	}
+	if err == nil && res.Index != nil && res.Index.Filter != nil {
+		_, err = fmt.Fprintf(w, "The filter saved %d of %d lookups.\n", res.Filtered, res.Hits+res.Misses+res.Duplicates)
+	}
+	return err
+}
+
+// checkFormat checks the -format flag.
+func checkFormat(format string) error {
+	if format != "text" && format != "json" {
+		return fmt.Errorf("unknown format %q, want text or json", format)
+	}
+	return nil
+}
+
+func init() {
+	var (
+		f       indexFlags
+		rf      rulesFlags
+		timeout time.Duration
+		nocheat bool
+		report  string
+		format  string
+		workers int
+		join    bool
+		tmpdir  string
+		sortmem int64
+		input   string
+	)
+	c := newCommand("score", "[passwordfile]", "Score the passwords in a file, one per line, or on stdin")
+	f.register(c.flags)
+	rf.register(c.flags)
+	rf.usage(c)
+	c.flags.DurationVar(&timeout, "timeout", 2*time.Minute, "Timeout")
+	c.flags.BoolVar(&nocheat, "nocheat", false, "Don't cheat at openwest competition?")
+	c.flags.StringVar(&report, "report", "", "Explain the score of every line as a "+strings.Join(scoreme.ReportFormats, ", ")+" report.")
+	c.flags.StringVar(&format, "format", "text", "Print the result as text or json. With json, a json report is included in the result.")
+	c.flags.IntVar(&workers, "workers", runtime.NumCPU(), "Goroutines looking up passwords in parallel. With 1 they are looked up one by one.")
+	c.flags.BoolVar(&join, "join", false, "Sort the hashes of the passwords on disk and score them in one pass through the index, for huge submissions.")
+	c.flags.StringVar(&tmpdir, "tmpdir", os.TempDir(), "Directory to sort the hashes in for -join.")
+	c.flags.Int64Var(&sortmem, "sortmem", 256, "Megabytes of hashes to sort in memory at a time for -join.")
+	c.flags.StringVar(&input, "input", scoreme.InputAuto, "Format of the password file, one of "+strings.Join(scoreme.Inputs, ", ")+". A hashcat potfile has hash:password lines and a john pot file $tag$hash:password lines, and lines whose password does not hash to their hash are rejected. With hash every line is a hex hash of the index algorithm, and is never picked by auto.")
+	c.run = func(args []string) int {
+		if code, ok := c.parse(args, 0, 1); !ok {
+			return code
+		}
+		if err := checkFormat(format); err != nil {
+			errorf("%s", err)
+			return exitUsage
+		}
+		if err := scoreme.CheckInput(input); err != nil {
+			errorf("%s", err)
+			return exitUsage
+		}
+		rules, err := rf.load()
+		if err != nil {
+			errorf("%s", err)
+			return exitUsage
+		}
+		var lines []scoreme.Line
+		var rep scoreme.Report
+		switch {
+		case report == "":
+		case format == "json" && report != "json":
+			errorf("only a json report can go with -format=json")
+			return exitUsage
+		case format == "json":
+		default:
+			if rep, err = scoreme.NewReport(report, os.Stdout); err != nil {
+				errorf("%s", err)
+				return exitUsage
+			}
+		}
+		if nocheat && !isAuth() {
+			errorf("Access Denied")
+			return exitFail
+		}
+		var in io.ReadCloser = os.Stdin
+		if name := c.flags.Arg(0); name != "" && name != "-" {
+			fh, err := os.Open(name)
+			if err != nil {
+				errorf("%s", err)
+				return exitFail
+			}
+			in = fh
+		}
+		defer in.Close()
+		idx, err := f.openFiltered()
+		if err != nil {
+			errorf("%s", err)
+			return exitFail
+		}
+		defer idx.Close()
+
+		scorer := &scoreme.Scorer{Index: idx, Rules: rules, Debug: f.debug, Workers: workers, Input: input}
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		scan := func(sc *bufio.Scanner, report func(scoreme.Line) error) error {
+			return scorer.Scan(ctx, sc, report)
+		}
+		if join {
+			scan = func(sc *bufio.Scanner, report func(scoreme.Line) error) error {
+				return scorer.Join(ctx, sc, report, scoreme.JoinOptions{TempDir: tmpdir, SortMemory: sortmem << 20})
+			}
+		}
+		start := time.Now()
+		done := make(chan error, 1)
+		go func() {
+			switch {
+			case rep != nil:
+				err := scan(bufio.NewScanner(in), rep.Write)
+				if cerr := rep.Close(); err == nil {
+					err = cerr
+				}
+				done <- err
+			case report != "":
+				done <- scan(bufio.NewScanner(in), func(l scoreme.Line) error {
+					lines = append(lines, l)
+					return nil
+				})
+			default:
+				done <- scan(bufio.NewScanner(in), nil)
+			}
+		}()
+		stopped := true
+		select {
+		case err = <-done:
+		case <-ctx.Done():
+			// The scan stops before its next line, unless it is stuck
+			// waiting for input.
+			select {
+			case err = <-done:
+			case <-time.After(time.Second):
+				err, stopped = ctx.Err(), false
+			}
+		}
+		res := scorer.Result()
+		if stopped {
+			res.Lines = lines
+		}
+		code := exitOK
+		switch {
+		case err == context.DeadlineExceeded:
+			errorf("Timeout (%s)", timeout)
+			code = exitTimeout
+			rules.TimeOut(&res)
+		case err != nil:
+			errorf("%s", err)
+			return exitFail
+		}
+		res.Elapsed = time.Since(start).Seconds()
+		res.Index = f.info(idx)
+		if err := writeResult(os.Stdout, format, res); err != nil {
+			errorf("%s", err)
+			return exitFail
+		}
+		return code
+	}
+}
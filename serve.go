@@ -0,0 +1,311 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"runtime"
+	"time"
+
+	"github.com/hagna/scoreme/pkg/scoreme"
+	"github.com/pkg/browser"
+)
+
+// defaultTeam scores every submission when the server runs without teams.
+const defaultTeam = "default"
+
+// server is the easy mode webserver.
+type server struct {
+	comp *scoreme.Competition
+	info *scoreme.IndexInfo
+	// admin is the token of the admin endpoints, which are off without it.
+	admin string
+	// teams is false when everyone plays as defaultTeam.
+	teams bool
+}
+
+// formScanner returns a scanner over the passwords posted by the easy mode
+// form, and the input type chosen on it if any. The form puts the input
+// type first, so the passwords can be scanned as they arrive.
+func formScanner(body io.Reader) (*bufio.Scanner, string, error) {
+	br := bufio.NewReader(body)
+	var input string
+	for {
+		key, err := br.ReadSlice('=')
+		if err != nil {
+			return nil, "", errors.New("the form has no passwords")
+		}
+		if string(key) == "passwords=" {
+			break
+		}
+		val, err := br.ReadSlice('&')
+		if err != nil {
+			return nil, "", errors.New("the form has no passwords")
+		}
+		if string(key) == "input=" {
+			input, _ = url.QueryUnescape(string(bytes.TrimSuffix(val, []byte("&"))))
+		}
+	}
+	s := bufio.NewScanner(br)
+	s.Split(scoreme.HTMLBodySplitter)
+	return s, input, nil
+}
+
+// queryInput returns the input format of a submission from the input
+// query value, plain if there is none.
+func queryInput(q url.Values) (string, error) {
+	input := q.Get("input")
+	if input == "" {
+		return scoreme.InputPlain, nil
+	}
+	return input, scoreme.CheckInput(input)
+}
+
+// team returns the team making the request, asking for basic auth
+// credentials if they are missing or wrong.
+func (s *server) team(w http.ResponseWriter, r *http.Request) (string, bool) {
+	if !s.teams {
+		return defaultTeam, true
+	}
+	name, token, ok := r.BasicAuth()
+	if !ok || !s.comp.Auth(name, token) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="scoreme team"`)
+		http.Error(w, "Access Denied", http.StatusUnauthorized)
+		return "", false
+	}
+	return name, true
+}
+
+func (s *server) isAdmin(w http.ResponseWriter, r *http.Request) bool {
+	if s.admin == "" {
+		http.NotFound(w, r)
+		return false
+	}
+	name, token, ok := r.BasicAuth()
+	if !ok || name != "admin" || subtle.ConstantTimeCompare([]byte(token), []byte(s.admin)) != 1 {
+		w.Header().Set("WWW-Authenticate", `Basic realm="scoreme admin"`)
+		http.Error(w, "Access Denied", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+func (s *server) handleForm(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html")
+	fmt.Fprintf(w, `<head></head><body><form action="/check?report=table" method="post">
+<select name="input">
+<option value="plain">Passwords</option>
+<option value="potfile">hashcat potfile</option>
+<option value="john">John pot file</option>
+<option value="hash">Hashes only</option>
+</select>
+<input type="submit"><br>
+<textarea rows="50" cols="40" name="passwords">Passwords go here</textarea>
+</form></body>`)
+}
+
+func (s *server) handleCheck(w http.ResponseWriter, r *http.Request) {
+	name, ok := s.team(w, r)
+	if !ok {
+		return
+	}
+	defer r.Body.Close()
+	q := r.URL.Query()
+	format, report := q.Get("format"), q.Get("report")
+	if format == "" {
+		format = "text"
+	}
+	if err := checkFormat(format); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	input, err := queryInput(q)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	sc, formInput, err := formScanner(r.Body)
+	if err == nil && formInput != "" {
+		input, err = formInput, scoreme.CheckInput(formInput)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var lines []scoreme.Line
+	var reportFn func(scoreme.Line) error
+	var rep scoreme.Report
+	switch {
+	case report == "":
+	case format == "json" && report != "json":
+		http.Error(w, "only a json report can go with format=json", http.StatusBadRequest)
+		return
+	case format == "json":
+		reportFn = func(l scoreme.Line) error {
+			lines = append(lines, l)
+			return nil
+		}
+	default:
+		var err error
+		if rep, err = scoreme.NewReport(report, w); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		reportFn = rep.Write
+	}
+	if format == "json" {
+		w.Header().Set("Content-Type", "application/json")
+	}
+	start := time.Now()
+	sub, err := s.comp.Submit(r.Context(), name, input, sc, func(l scoreme.Line) error {
+		if l.Verdict == scoreme.Hit {
+			log.Printf("%s hit %s\n", name, l.Hash)
+		}
+		if reportFn != nil {
+			return reportFn(l)
+		}
+		return nil
+	})
+	if err != nil {
+		errorf("%s: %s", name, err)
+		if sub == nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	if rep != nil {
+		rep.Close()
+		fmt.Fprintln(w)
+	}
+	res := sub.Total
+	res.Lines = lines
+	res.Elapsed = time.Since(start).Seconds()
+	res.Index = s.info
+	writeResult(w, format, res)
+}
+
+// handleTeams lists the teams on GET and registers one on POST, from the
+// form values name and token. A token is made up when none is given.
+func (s *server) handleTeams(w http.ResponseWriter, r *http.Request) {
+	if !s.isAdmin(w, r) {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	switch r.Method {
+	case "GET":
+		json.NewEncoder(w).Encode(s.comp.Teams())
+	case "POST":
+		name, token := r.FormValue("name"), r.FormValue("token")
+		if token == "" {
+			var err error
+			if token, err = scoreme.NewToken(); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+		if err := s.comp.AddTeam(name, token); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"name": name, "token": token})
+	default:
+		http.Error(w, "GET or POST only", http.StatusMethodNotAllowed)
+	}
+}
+
+func init() {
+	var (
+		f         indexFlags
+		rf        rulesFlags
+		addr      string
+		open      bool
+		nocheat   bool
+		state     string
+		teamsfile string
+		admin     string
+		workers   int
+	)
+	c := newCommand("serve", "", "Run the easy mode webserver for scoring passwords from a browser")
+	f.register(c.flags)
+	rf.register(c.flags)
+	rf.usage(c)
+	c.flags.StringVar(&addr, "addr", ":8080", "Easy mode webserver addr")
+	c.flags.BoolVar(&open, "browser", true, "Open the form in a browser.")
+	c.flags.BoolVar(&nocheat, "nocheat", false, "Don't cheat at openwest competition?")
+	c.flags.StringVar(&state, "state", "./scoreme.state", "Boltdb file keeping the teams and their scores across restarts.")
+	c.flags.StringVar(&teamsfile, "teams", "", "TOML file of [[team]] tables with a name and token to register.")
+	c.flags.IntVar(&workers, "workers", runtime.NumCPU(), "Goroutines looking up the passwords of a submission in parallel.")
+	c.flags.StringVar(&admin, "admintoken", "", "Password of the admin user for /admin/teams. Without teams or an admin token everyone scores as one team.")
+	c.run = func(args []string) int {
+		if code, ok := c.parse(args, 0, 0); !ok {
+			return code
+		}
+		rules, err := rf.load()
+		if err != nil {
+			errorf("%s", err)
+			return exitUsage
+		}
+		if nocheat && !isAuth() {
+			errorf("Access Denied")
+			return exitFail
+		}
+		idx, err := f.openFiltered()
+		if err != nil {
+			errorf("%s", err)
+			return exitFail
+		}
+		defer idx.Close()
+		comp, err := scoreme.OpenCompetition(state, idx, rules, workers)
+		if err != nil {
+			errorf("%s: %s", state, err)
+			return exitFail
+		}
+		defer comp.Close()
+		if teamsfile != "" {
+			if err := comp.LoadTeams(teamsfile); err != nil {
+				errorf("%s", err)
+				return exitFail
+			}
+		}
+
+		s := &server{comp: comp, info: f.info(idx), admin: admin}
+		s.teams = teamsfile != "" || admin != ""
+		if !s.teams {
+			token, err := scoreme.NewToken()
+			if err == nil {
+				err = comp.AddTeam(defaultTeam, token)
+			}
+			if err != nil {
+				errorf("%s", err)
+				return exitFail
+			}
+		}
+		mux := http.NewServeMux()
+		mux.HandleFunc("/", s.handleForm)
+		mux.HandleFunc("/check", s.handleCheck)
+		mux.HandleFunc("/admin/teams", s.handleTeams)
+		mux.HandleFunc("/leaderboard", s.handleLeaderboard)
+		mux.HandleFunc("/api/v1/submissions", s.handleSubmissions)
+		mux.HandleFunc("/api/v1/submissions/", s.handleSubmission)
+		mux.HandleFunc("/api/v1/score", s.handleScore)
+		mux.HandleFunc("/leaderboard/stream", s.handleLeaderboardStream)
+		if open {
+			go func() {
+				time.Sleep(1 * time.Second)
+				browser.OpenURL("http://127.0.0.1" + addr + "/")
+			}()
+		}
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			errorf("%s", err)
+			return exitFail
+		}
+		return exitOK
+	}
+}
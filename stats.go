@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/hagna/scoreme/pkg/scoreme"
+)
+
+func init() {
+	var f indexFlags
+	c := newCommand("stats", "", "Print the number of records and breaches in the index")
+	f.register(c.flags)
+	c.run = func(args []string) int {
+		if code, ok := c.parse(args, 0, 0); !ok {
+			return code
+		}
+		idx, err := f.open()
+		if err != nil {
+			errorf("%s", err)
+			return exitFail
+		}
+		defer idx.Close()
+		w, ok := idx.(scoreme.Walker)
+		if !ok {
+			errorf("the %s backend can't list its records", f.backend)
+			return exitFail
+		}
+		var records, breaches, once int
+		prefixes := make(map[string]bool)
+		m := idx.Meta()
+		err = w.Walk(func(h string, count int) error {
+			records++
+			breaches += count
+			if count == 1 {
+				once++
+			}
+			prefixes[h[:m.PrefixLen]] = true
+			return nil
+		})
+		if err != nil {
+			errorf("%s", err)
+			return exitFail
+		}
+		fmt.Printf("backend    %s\n", m.Backend)
+		fmt.Printf("index      %s\n", f.options().Path)
+		fmt.Printf("version    %d\n", m.Version)
+		fmt.Printf("algorithm  %s\n", m.Algorithm)
+		fmt.Printf("prefixlen  %d\n", m.PrefixLen)
+		if m.SplitLen != 0 {
+			fmt.Printf("splitlen   %d\n", m.SplitLen)
+		}
+		if m.Source != nil {
+			fmt.Printf("source     %s (%d bytes, sha256 %s)\n", m.Source.Name, m.Source.Size, m.Source.SHA256)
+		}
+		if cp := m.Checkpoint; cp != nil {
+			fmt.Printf("unfinished %s stopped at byte %d of %d, %s\n", cp.Name, cp.Offset, cp.Size, cp.Time.Format(time.RFC3339))
+		}
+		if !m.Built.IsZero() {
+			fmt.Printf("built      %s\n", m.Built.Format(time.RFC3339))
+		}
+		fmt.Printf("prefixes   %d\n", len(prefixes))
+		fmt.Printf("records    %d\n", records)
+		fmt.Printf("breaches   %d\n", breaches)
+		fmt.Printf("seen once  %d\n", once)
+		return exitOK
+	}
+}
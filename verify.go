@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/hagna/scoreme/pkg/scoreme"
+)
+
+func init() {
+	var f indexFlags
+	c := newCommand("verify", "[passwdfile]", "Check the layout of the index and, given the passwd file it was built from, that every line can be looked up")
+	f.register(c.flags)
+	c.run = func(args []string) int {
+		if code, ok := c.parse(args, 0, 1); !ok {
+			return code
+		}
+		idx, err := f.open()
+		if err != nil {
+			errorf("%s", err)
+			return exitFail
+		}
+		defer idx.Close()
+
+		var bad int
+		res, err := scoreme.Check(idx, func(p scoreme.Problem) {
+			fmt.Println(p)
+			bad++
+		})
+		if err != nil {
+			errorf("%s", err)
+			return exitFail
+		}
+		fmt.Printf("%d keys and %d records checked, %d problems\n", res.Keys, res.Records, bad)
+
+		var checked int
+		check := func(h string, count int) error {
+			checked++
+			got, err := idx.Lookup(h)
+			switch {
+			case err == scoreme.ErrNotFound:
+				fmt.Printf("%s missing\n", h)
+				bad++
+			case err != nil:
+				return err
+			case got != count:
+				fmt.Printf("%s has count %d, want %d\n", h, got, count)
+				bad++
+			}
+			return nil
+		}
+
+		if name := c.flags.Arg(0); name != "" {
+			pfile, err := os.Open(name)
+			if err != nil {
+				errorf("%s", err)
+				return exitFail
+			}
+			defer pfile.Close()
+			p := bufio.NewScanner(pfile)
+			for p.Scan() {
+				l := strings.TrimSpace(p.Text())
+				if l == "" {
+					continue
+				}
+				i := strings.LastIndex(l, ":")
+				if i == -1 {
+					errorf("No \":\" in record \"%s\"", l)
+					return exitFail
+				}
+				count, err := strconv.Atoi(strings.TrimSpace(l[i+1:]))
+				if err != nil {
+					errorf("%s", err)
+					return exitFail
+				}
+				if err := check(strings.ToUpper(l[:i]), count); err != nil {
+					errorf("%s", err)
+					return exitFail
+				}
+			}
+			if err := p.Err(); err != nil {
+				errorf("%s", err)
+				return exitFail
+			}
+			fmt.Printf("%d passwd lines checked\n", checked)
+		}
+		if bad > 0 {
+			return exitFail
+		}
+		return exitOK
+	}
+}